@@ -1,17 +1,339 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"net/netip"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/drkgrkn/dnsresolver/protocol"
 )
 
+// exit codes, the first failing name decides
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNXDomain = 3
+	exitServFail = 4
+	exitTimeout  = 5
+)
+
+const usage = `usage: %s [flags] name...
+
+Resolves each name iteratively from the root servers, printing its IPv4
+and IPv6 addresses unless -t is given.
+
+Exit codes: 1 error, 2 usage, 3 no such name or records, or no name
+confirmed with -verify, 4 server failure, 5 timeout.
+
+Flags:
+`
+
+type options struct {
+	qtype   string
+	server  string
+	tcp     bool
+	timeout time.Duration
+	trace   bool
+	reverse bool
+	verify  bool
+	json    bool
+	full    bool
+}
+
 func main() {
-	domain := os.Args[1]
-	ips := protocol.Find(domain)
-	fmt.Printf("IP addresses of %s\n", domain)
-	for _, ip := range ips {
-		fmt.Printf("  - %s\n", ip)
+	var opts options
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.StringVar(&opts.qtype, "t", "", "record `type` to look up, such as MX or TYPE65")
+	flag.StringVar(&opts.qtype, "type", "", "same as -t")
+	flag.StringVar(&opts.server, "s", "", "recursive resolver `address` to ask instead of resolving from the root, with an optional port")
+	flag.StringVar(&opts.server, "server", "", "same as -s")
+	flag.BoolVar(&opts.tcp, "tcp", false, "query over TCP only")
+	flag.DurationVar(&opts.timeout, "timeout", 2*time.Second, "time to wait for each response")
+	flag.BoolVar(&opts.trace, "trace", false, "print every query sent while resolving")
+	flag.BoolVar(&opts.reverse, "x", false, "look up the names of addresses")
+	flag.BoolVar(&opts.verify, "verify", false, "with -x, keep only the names that resolve back to the address")
+	flag.BoolVar(&opts.json, "json", false, "print the results as JSON messages, in the format of RFC 8427, synthesized like with -full")
+	flag.BoolVar(&opts.full, "full", false, "print each result as a whole message the way dig does, synthesized from the records found rather than received from a server")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	os.Exit(run(opts, flag.Args()))
+}
+
+// lookup is a name to resolve with the types to resolve it for, and the
+// address it is the reverse name of with -x.
+type lookup struct {
+	name   string
+	qtypes []uint16
+	addr   netip.Addr
+}
+
+// outcome is what resolving a lookup for one of its types led to.
+type outcome struct {
+	name   string
+	qtype  uint16
+	result protocol.Result
+	err    error
+}
+
+func run(opts options, args []string) int {
+	resolverOpts, err := resolverOptions(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	lookups, err := lookupsOf(opts, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	r := protocol.NewResolver(resolverOpts...)
+
+	code := exitOK
+	all := []outcome{}
+	for _, l := range lookups {
+		outcomes := []outcome{}
+		for _, qtype := range l.qtypes {
+			result, err := r.Resolve(context.Background(), l.name, qtype)
+			outcomes = append(outcomes, outcome{name: l.name, qtype: qtype, result: result, err: err})
+		}
+		if opts.verify {
+			verify(r, l.addr, outcomes)
+		}
+		all = append(all, outcomes...)
+
+		if opts.full && !opts.json {
+			for _, o := range outcomes {
+				fmt.Printf(";; synthesized from the resolution of %s %s, not received from a server\n%s\n",
+					o.name, protocol.TypeString(o.qtype), responseOf(o))
+			}
+		}
+		if err := lookupError(outcomes); err != nil {
+			fmt.Fprintf(os.Stderr, "error resolving %s: %s\n", l.name, err)
+			if code == exitOK {
+				code = exitCode(err)
+			}
+			continue
+		}
+		if !opts.json && !opts.full {
+			printOutcomes(opts, l.name, outcomes)
+		}
+	}
+
+	if opts.json {
+		if err := printJSON(all); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing json: %s\n", err)
+			return exitError
+		}
+	}
+	return code
+}
+
+func resolverOptions(opts options) ([]protocol.ResolverOptsFunc, error) {
+	resolverOpts := []protocol.ResolverOptsFunc{
+		protocol.WithTCPOnly(opts.tcp),
+		protocol.WithTimeout(opts.timeout),
+	}
+	if opts.trace {
+		resolverOpts = append(resolverOpts, protocol.WithTrace(printTraceStep))
+	}
+	if opts.server != "" {
+		server, err := parseServer(opts.server)
+		if err != nil {
+			return nil, err
+		}
+		resolverOpts = append(resolverOpts,
+			protocol.WithForwarders(server.Addr()),
+			protocol.WithPort(server.Port()),
+		)
+	}
+	return resolverOpts, nil
+}
+
+// parseServer parses an address with an optional port, brackets around
+// IPv6 addresses being needed with a port only.
+func parseServer(s string) (netip.AddrPort, error) {
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort, nil
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid server %q: %w", s, err)
+	}
+	return netip.AddrPortFrom(addr, 53), nil
+}
+
+func lookupsOf(opts options, args []string) ([]lookup, error) {
+	if opts.verify && !opts.reverse {
+		return nil, errors.New("-verify only applies to -x")
+	}
+	qtypes := []uint16{protocol.RecordTypeA, protocol.RecordTypeAAAA}
+	if opts.reverse {
+		qtypes = []uint16{protocol.RecordTypePTR}
+	}
+	if opts.qtype != "" {
+		qtype, ok := protocol.ParseType(opts.qtype)
+		if !ok {
+			return nil, fmt.Errorf("unknown record type %q", opts.qtype)
+		}
+		qtypes = []uint16{qtype}
+	}
+
+	lookups := []lookup{}
+	for _, arg := range args {
+		l := lookup{name: arg, qtypes: qtypes}
+		if opts.reverse {
+			addr, err := netip.ParseAddr(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", arg, err)
+			}
+			if l.name, err = protocol.ReverseName(addr); err != nil {
+				return nil, err
+			}
+			l.addr = addr
+		}
+		lookups = append(lookups, l)
+	}
+	return lookups, nil
+}
+
+// verify drops the PTR records of outcomes whose names don't resolve back
+// to addr, failing the outcomes left without any.
+func verify(r *protocol.Resolver, addr netip.Addr, outcomes []outcome) {
+	names, err := r.VerifiedReverseLookup(context.Background(), addr)
+	for i, o := range outcomes {
+		if o.err != nil || o.qtype != protocol.RecordTypePTR {
+			continue
+		}
+		if err != nil {
+			outcomes[i].err = err
+			continue
+		}
+		outcomes[i].result.Answers = slices.DeleteFunc(slices.Clone(o.result.Answers), func(rr protocol.ResourceRecord) bool {
+			ptr, ok := rr.RData.(protocol.PTR)
+			return ok && !slices.Contains(names, ptr.Host.String())
+		})
+	}
+}
+
+// lookupError returns the error of the first type that failed when all
+// of them did.
+func lookupError(outcomes []outcome) error {
+	for _, o := range outcomes {
+		if o.err == nil {
+			return nil
+		}
+	}
+	return outcomes[0].err
+}
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, protocol.ErrNXDomain), errors.Is(err, protocol.ErrNoData), errors.Is(err, protocol.ErrNotConfirmed):
+		return exitNXDomain
+	case errors.Is(err, protocol.ErrTimeout):
+		return exitTimeout
+	case errors.Is(err, protocol.ErrServerFailure),
+		errors.Is(err, protocol.ErrLameDelegation),
+		errors.Is(err, protocol.ErrMalformedResponse),
+		errors.Is(err, protocol.ErrMismatchedResponse),
+		errors.Is(err, protocol.ErrCNAMELoop),
+		errors.Is(err, protocol.ErrCNAMEChainTooLong):
+		return exitServFail
+	default:
+		return exitError
+	}
+}
+
+// printOutcomes prints the addresses of name when looking up addresses,
+// and the records found otherwise.
+func printOutcomes(opts options, name string, outcomes []outcome) {
+	if opts.qtype == "" && !opts.reverse {
+		fmt.Printf("IP addresses of %s\n", name)
+		for _, o := range outcomes {
+			for _, addr := range o.result.Addresses() {
+				fmt.Printf("  - %s\n", addr)
+			}
+		}
+		return
+	}
+
+	for _, o := range outcomes {
+		for _, rr := range slices.Concat(o.result.Chain, o.result.Answers) {
+			fmt.Println(rr)
+		}
+	}
+}
+
+// responseOf synthesizes the response a recursive resolver would have
+// sent for o. No server sent it: the records may have been found across
+// several zones or in the cache.
+func responseOf(o outcome) protocol.Message {
+	rcode := protocol.RCodeNoError
+	switch {
+	case errors.Is(o.err, protocol.ErrNXDomain):
+		rcode = protocol.RCodeNXDomain
+	case o.err != nil && !errors.Is(o.err, protocol.ErrNoData):
+		rcode = protocol.RCodeServFail
+	}
+
+	msg := protocol.NewMessage(
+		protocol.WithResponse(),
+		protocol.WithRecursionDesired(),
+		protocol.WithRecursionAvailable(),
+		protocol.WithRCode(rcode),
+		protocol.WithQuestion(o.name, o.qtype, protocol.RecordClassIN),
+	)
+	msg.Answers = slices.Concat(o.result.Chain, o.result.Answers)
+	return msg
+}
+
+// printJSON prints the responses synthesized for outcomes as an array of
+// RFC 8427 messages.
+func printJSON(outcomes []outcome) error {
+	msgs := []protocol.Message{}
+	for _, o := range outcomes {
+		msgs = append(msgs, responseOf(o))
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(msgs)
+}
+
+// printTraceStep prints the records a server returned followed by where
+// they came from, the way dig +trace does.
+func printTraceStep(step protocol.TraceStep) {
+	q := step.Query.Questions[0]
+	if step.Err != nil {
+		fmt.Printf(";; %s %s to %s for %s. failed after %d ms (attempt %d): %s\n\n",
+			q.QName, protocol.TypeString(q.QType), step.Server, step.Zone, step.Latency.Milliseconds(), step.Attempt, step.Err)
+		return
+	}
+
+	resp := step.Response
+	for _, section := range [][]protocol.ResourceRecord{resp.Answers, resp.Authority, resp.Additional} {
+		for _, rr := range section {
+			if rr.Type == protocol.RecordTypeOPT {
+				continue
+			}
+			fmt.Println(rr)
+		}
 	}
+	fmt.Printf(";; %s %s: %s from %s for %s. in %d ms (attempt %d)\n\n",
+		q.QName, protocol.TypeString(q.QType), resp.RCode(), step.Server, step.Zone, step.Latency.Milliseconds(), step.Attempt)
 }
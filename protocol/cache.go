@@ -0,0 +1,263 @@
+package protocol
+
+import (
+	"container/list"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultCacheSize = 4096
+
+// typeNXDomain is the type under which a non-existent name is cached, as it
+// applies to every type of the name.
+const typeNXDomain uint16 = 0
+
+type cacheKey struct {
+	name  string
+	typ   uint16
+	class uint16
+}
+
+func newCacheKey(name string, typ uint16, class uint16) cacheKey {
+	return cacheKey{
+		name:  strings.ToLower(strings.TrimSuffix(name, ".")),
+		typ:   typ,
+		class: class,
+	}
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	records  []ResourceRecord
+	negative bool
+	// glue entries only serve to reach nameservers and are never answers
+	glue     bool
+	storedAt time.Time
+	expires  time.Time
+}
+
+// CacheStats holds counters describing how a Cache has been used.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+// Cache is a size bounded, concurrency safe store of RRsets keyed by owner
+// name, type and class. Entries expire with the smallest TTL of their
+// records and the least recently used entry is evicted when the cache is
+// full.
+type Cache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[cacheKey]*list.Element
+	lru      *list.List
+	stats    CacheStats
+	now      func() time.Time
+}
+
+// NewCache returns a cache holding at most capacity RRsets.
+func NewCache(capacity int) *Cache {
+	return &Cache{
+		capacity: max(capacity, 1),
+		entries:  make(map[cacheKey]*list.Element),
+		lru:      list.New(),
+		now:      time.Now,
+	}
+}
+
+// Get returns the RRset stored for name, typ and class with TTLs reduced
+// by the time spent in the cache.
+func (c *Cache) Get(name string, typ uint16, class uint16) ([]ResourceRecord, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	records, ok := c.get(newCacheKey(name, typ, class), false)
+	c.count(ok)
+	return records, ok
+}
+
+// peek is Get without counting in the stats, for the lookups made on the
+// way to an answer rather than for one.
+func (c *Cache) peek(name string, typ uint16, class uint16) ([]ResourceRecord, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.get(newCacheKey(name, typ, class), false)
+}
+
+// getGlue is peek for finding the addresses of nameservers, which glue may
+// provide.
+func (c *Cache) getGlue(name string, typ uint16, class uint16) ([]ResourceRecord, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.get(newCacheKey(name, typ, class), true)
+}
+
+func (c *Cache) get(key cacheKey, glue bool) ([]ResourceRecord, bool) {
+	entry, ok := c.lookup(key)
+	if !ok || entry.negative || (entry.glue && !glue) {
+		return nil, false
+	}
+
+	elapsed := uint32(c.now().Sub(entry.storedAt) / time.Second)
+	records := slices.Clone(entry.records)
+	for i := range records {
+		records[i].TTL -= min(elapsed, records[i].TTL)
+	}
+	return records, true
+}
+
+// GetNegative reports whether name is cached as non-existent, or as having
+// no records of typ and class. Misses are not counted as GetNegative is
+// meant to be consulted after Get.
+func (c *Cache) GetNegative(name string, typ uint16, class uint16) (nxdomain bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	nxdomain, ok = c.getNegative(name, typ, class)
+	if ok {
+		c.stats.Hits++
+	}
+	return nxdomain, ok
+}
+
+// peekNegative is GetNegative without counting in the stats.
+func (c *Cache) peekNegative(name string, typ uint16, class uint16) (nxdomain bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getNegative(name, typ, class)
+}
+
+func (c *Cache) getNegative(name string, typ uint16, class uint16) (nxdomain bool, ok bool) {
+	if entry, ok := c.lookup(newCacheKey(name, typeNXDomain, class)); ok && entry.negative {
+		return true, true
+	}
+	if entry, ok := c.lookup(newCacheKey(name, typ, class)); ok && entry.negative {
+		return false, true
+	}
+	return false, false
+}
+
+// countLookup counts a lookup made with peek and peekNegative as a hit or
+// a miss.
+func (c *Cache) countLookup(hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count(hit)
+}
+
+func (c *Cache) count(hit bool) {
+	if hit {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+}
+
+// Set stores records, which must all share the same owner name, type and
+// class, replacing whatever was stored for them before. RRsets with a zero
+// TTL are not stored.
+func (c *Cache) Set(name string, typ uint16, class uint16, records []ResourceRecord) {
+	c.set(name, typ, class, records, false)
+}
+
+// setGlue stores the addresses of a nameserver that came as glue with a
+// referral, unless records of the name's own zone are stored already.
+func (c *Cache) setGlue(name string, typ uint16, class uint16, records []ResourceRecord) {
+	c.set(name, typ, class, records, true)
+}
+
+func (c *Cache) set(name string, typ uint16, class uint16, records []ResourceRecord, glue bool) {
+	if len(records) == 0 {
+		return
+	}
+	ttl := records[0].TTL
+	for _, rr := range records[1:] {
+		ttl = min(ttl, rr.TTL)
+	}
+	if ttl == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := newCacheKey(name, typ, class)
+	if entry, ok := c.lookup(key); glue && ok && !entry.glue {
+		return
+	}
+	c.store(&cacheEntry{
+		key:     key,
+		records: slices.Clone(records),
+		glue:    glue,
+	}, ttl)
+}
+
+// SetNegative stores that name does not exist, when nxdomain is set, or
+// that it has no records of typ and class, for ttl seconds as derived from
+// the SOA record of the response per RFC 2308.
+func (c *Cache) SetNegative(name string, typ uint16, class uint16, nxdomain bool, ttl uint32) {
+	if ttl == 0 {
+		return
+	}
+	if nxdomain {
+		typ = typeNXDomain
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store(&cacheEntry{
+		key:      newCacheKey(name, typ, class),
+		negative: true,
+	}, ttl)
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	return stats
+}
+
+func (c *Cache) lookup(key cacheKey) (*cacheEntry, bool) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return entry, true
+}
+
+func (c *Cache) store(entry *cacheEntry, ttl uint32) {
+	if elem, ok := c.entries[entry.key]; ok {
+		c.remove(elem)
+	}
+
+	entry.storedAt = c.now()
+	entry.expires = entry.storedAt.Add(time.Duration(ttl) * time.Second)
+	c.entries[entry.key] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+}
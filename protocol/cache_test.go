@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func testRecordA(name string, ttl uint32, ip string) ResourceRecord {
+	return ResourceRecord{
+		Name:  NewDomainName(name),
+		Type:  RecordTypeA,
+		Class: RecordClassIN,
+		TTL:   ttl,
+		RData: A{Addr: netip.MustParseAddr(ip)},
+	}
+}
+
+func Test_cacheTTL(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := NewCache(10)
+	c.now = func() time.Time { return now }
+
+	c.Set("dns.google.com", RecordTypeA, RecordClassIN, []ResourceRecord{
+		testRecordA("dns.google.com", 300, "8.8.8.8"),
+		testRecordA("dns.google.com", 60, "8.8.4.4"),
+	})
+
+	now = now.Add(45 * time.Second)
+	got, ok := c.Get("DNS.Google.com.", RecordTypeA, RecordClassIN)
+	if !ok {
+		t.Fatalf("expected cache hit")
+	}
+	if got[0].TTL != 255 || got[1].TTL != 15 {
+		t.Fatalf("expected ttls to be decremented to\n%v\nbut got\n%v\n", []uint32{255, 15}, []uint32{got[0].TTL, got[1].TTL})
+	}
+
+	now = now.Add(15 * time.Second)
+	if _, ok := c.Get("dns.google.com", RecordTypeA, RecordClassIN); ok {
+		t.Fatalf("expected rrset to expire with its smallest ttl")
+	}
+
+	want := CacheStats{Hits: 1, Misses: 1, Size: 0}
+	if got := c.Stats(); got != want {
+		t.Fatalf("expected stats to be\n%+v\nbut got\n%+v\n", want, got)
+	}
+}
+
+func Test_cacheLRU(t *testing.T) {
+	c := NewCache(2)
+
+	c.Set("a.example", RecordTypeA, RecordClassIN, []ResourceRecord{testRecordA("a.example", 60, "1.1.1.1")})
+	c.Set("b.example", RecordTypeA, RecordClassIN, []ResourceRecord{testRecordA("b.example", 60, "2.2.2.2")})
+	c.Get("a.example", RecordTypeA, RecordClassIN)
+	c.Set("c.example", RecordTypeA, RecordClassIN, []ResourceRecord{testRecordA("c.example", 60, "3.3.3.3")})
+
+	if _, ok := c.Get("b.example", RecordTypeA, RecordClassIN); ok {
+		t.Fatalf("expected least recently used rrset to be evicted")
+	}
+	for _, name := range []string{"a.example", "c.example"} {
+		if _, ok := c.Get(name, RecordTypeA, RecordClassIN); !ok {
+			t.Fatalf("expected %s to still be cached", name)
+		}
+	}
+	if got := c.Stats().Evictions; got != 1 {
+		t.Fatalf("expected 1 eviction but got %d", got)
+	}
+}
+
+func Test_cacheNegative(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := NewCache(10)
+	c.now = func() time.Time { return now }
+
+	c.SetNegative("typo.example", RecordTypeA, RecordClassIN, true, 30)
+	c.SetNegative("www.example", RecordTypeAAAA, RecordClassIN, false, 60)
+
+	if nxdomain, ok := c.GetNegative("typo.example", RecordTypeCNAME, RecordClassIN); !ok || !nxdomain {
+		t.Fatalf("expected nxdomain to apply to every type of the name")
+	}
+	if nxdomain, ok := c.GetNegative("www.example", RecordTypeAAAA, RecordClassIN); !ok || nxdomain {
+		t.Fatalf("expected nodata to be cached for the queried type")
+	}
+	if _, ok := c.GetNegative("www.example", RecordTypeA, RecordClassIN); ok {
+		t.Fatalf("expected nodata not to apply to other types")
+	}
+	if _, ok := c.Get("www.example", RecordTypeAAAA, RecordClassIN); ok {
+		t.Fatalf("expected negative entries not to be returned as rrsets")
+	}
+
+	now = now.Add(30 * time.Second)
+	if _, ok := c.GetNegative("typo.example", RecordTypeA, RecordClassIN); ok {
+		t.Fatalf("expected negative entry to expire with its ttl")
+	}
+}
@@ -1,15 +1,89 @@
 package protocol
 
+import (
+	"strconv"
+	"strings"
+)
+
 const (
 	// record type
 	RecordTypeA     uint16 = 1
 	RecordTypeNS    uint16 = 2
 	RecordTypeCNAME uint16 = 5
+	RecordTypeSOA   uint16 = 6
+	RecordTypePTR   uint16 = 12
+	RecordTypeMX    uint16 = 15
+	RecordTypeTXT   uint16 = 16
 	RecordTypeAAAA  uint16 = 28
+	RecordTypeSRV   uint16 = 33
+	RecordTypeNAPTR uint16 = 35
+	RecordTypeOPT   uint16 = 41
+	RecordTypeSSHFP uint16 = 44
+	RecordTypeTLSA  uint16 = 52
+	RecordTypeSVCB  uint16 = 64
+	RecordTypeHTTPS uint16 = 65
+	RecordTypeURI   uint16 = 256
+	RecordTypeCAA   uint16 = 257
 
 	// record class
 	RecordClassIN uint16 = 1
 
 	// other
 	offsetFlagExcess uint16 = 0b11000000 << 8
+	labelTypeMask    byte   = 0b11000000
+	labelTypePointer byte   = 0b11000000
+	maxLabelLength          = 63
+	maxNameLength           = 255
+	maxStringLength         = 255
+	soaFixedLength   uint16 = 5 * 4
+
+	// the root name followed by type and class, plus ttl and rdlength for
+	// records
+	minQuestionLength = 1 + 4
+	minRecordLength   = 1 + 10
 )
+
+var typeNames = map[uint16]string{
+	RecordTypeA:     "A",
+	RecordTypeNS:    "NS",
+	RecordTypeCNAME: "CNAME",
+	RecordTypeSOA:   "SOA",
+	RecordTypePTR:   "PTR",
+	RecordTypeMX:    "MX",
+	RecordTypeTXT:   "TXT",
+	RecordTypeAAAA:  "AAAA",
+	RecordTypeSRV:   "SRV",
+	RecordTypeNAPTR: "NAPTR",
+	RecordTypeOPT:   "OPT",
+	RecordTypeSSHFP: "SSHFP",
+	RecordTypeTLSA:  "TLSA",
+	RecordTypeSVCB:  "SVCB",
+	RecordTypeHTTPS: "HTTPS",
+	RecordTypeURI:   "URI",
+	RecordTypeCAA:   "CAA",
+}
+
+// TypeString returns the mnemonic of record type t, or TYPEn for types
+// without one as RFC 3597 has it.
+func TypeString(t uint16) string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// ParseType returns the record type named s, a mnemonic or TYPEn, case
+// insensitively.
+func ParseType(s string) (uint16, bool) {
+	s = strings.ToUpper(s)
+	for t, name := range typeNames {
+		if name == s {
+			return t, true
+		}
+	}
+	if n, ok := strings.CutPrefix(s, "TYPE"); ok {
+		t, err := strconv.ParseUint(n, 10, 16)
+		return uint16(t), err == nil
+	}
+	return 0, false
+}
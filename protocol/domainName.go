@@ -2,7 +2,9 @@ package protocol
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"strconv"
 	"strings"
 )
 
@@ -15,34 +17,10 @@ func (l Label) isZero() bool {
 	return l.length == 0
 }
 
-func (l Label) isOffset() bool {
-	return l.length >= offsetFlagExcess
-}
-
-func (l Label) offset() int {
-	offset := int(l.length - offsetFlagExcess)
-	return offset
-}
-
-func (l Label) len() int {
-	if l.isZero() {
-		return 1
-	}
-
-	if l.isOffset() {
-		return 2
-	}
-
-	return 1 + len(l.str)
-}
-
 func (l Label) Bytes() []byte {
 	if l.isZero() {
 		return []byte{0}
 	}
-	if l.isOffset() {
-		return UInt16ToByteSlice(l.length)
-	}
 	lByte := []byte{byte(l.length)}
 	return append(lByte, []byte(l.str)...)
 }
@@ -51,18 +29,22 @@ type DomainName struct {
 	labels []Label
 }
 
-func newDomainName(dn string) DomainName {
+// NewDomainName returns the name dn, given in dotted form with or without
+// the trailing dot of the root.
+func NewDomainName(dn string) DomainName {
 	labels := make([]Label, 0)
-	words := strings.Split(dn, ".")
-
-	for _, word := range words {
-		labels = append(
-			labels,
-			Label{
-				length: uint16(len(word)),
-				str:    word,
-			},
-		)
+	dn = strings.TrimSuffix(dn, ".")
+
+	if dn != "" {
+		for _, word := range strings.Split(dn, ".") {
+			labels = append(
+				labels,
+				Label{
+					length: uint16(len(word)),
+					str:    word,
+				},
+			)
+		}
 	}
 	labels = append(labels, Label{
 		length: 0,
@@ -74,45 +56,46 @@ func newDomainName(dn string) DomainName {
 	}
 }
 
-func newIPAddress(dn string) DomainName {
-
-	return DomainName{
-		labels: []Label{
-			{
-				length: 4,
-				str:    dn,
-			},
-			{
-				length: 0,
-				str:    "",
-			},
-		},
-	}
-}
-
-func parseDomainName(r io.Reader) (DomainName, error) {
+// parseDomainName reads a name from r, following compression pointers into
+// the message r reads from. Pointers have to point before the start of the
+// labels they were found in, which rules out forward pointers and loops.
+func parseDomainName(r wireReader) (DomainName, error) {
 	labels := make([]Label, 0)
+	length := 0
 
+	cur := r
+	start := r.offset()
 	lengthBuf := make([]byte, 1)
 	for {
-		_, err := r.Read(lengthBuf)
+		_, err := cur.Read(lengthBuf)
 		if err != nil {
-			return DomainName{}, err
+			return DomainName{}, fmt.Errorf("reading label length at %d: %w", cur.offset(), io.ErrUnexpectedEOF)
 		}
 
 		// name pointer
-		if lengthBuf[0] >= 0b11000000 {
-			labelLength := uint16(lengthBuf[0]) << 8
-			_, err := r.Read(lengthBuf)
+		if lengthBuf[0]&labelTypeMask == labelTypePointer {
+			pointer := uint16(lengthBuf[0]) << 8
+			_, err := cur.Read(lengthBuf)
 			if err != nil {
-				return DomainName{}, err
+				return DomainName{}, fmt.Errorf("reading pointer at %d: %w", cur.offset()-1, io.ErrUnexpectedEOF)
 			}
-			labelLength += uint16(lengthBuf[0])
-			labels = append(labels, Label{
-				length: labelLength,
-				str:    "",
-			})
-			break
+			pointer += uint16(lengthBuf[0])
+
+			target := int(pointer - offsetFlagExcess)
+			if target >= start {
+				return DomainName{}, fmt.Errorf("pointer at %d to %d does not point backwards", cur.offset()-2, target)
+			}
+			start = target
+			cur = r.at(target)
+			continue
+		}
+		if lengthBuf[0]&labelTypeMask != 0 {
+			return DomainName{}, fmt.Errorf("unsupported label type %#x", lengthBuf[0]&labelTypeMask)
+		}
+
+		length += 1 + int(lengthBuf[0])
+		if length > maxNameLength {
+			return DomainName{}, fmt.Errorf("name is longer than %d bytes", maxNameLength)
 		}
 
 		// normal label
@@ -124,9 +107,9 @@ func parseDomainName(r io.Reader) (DomainName, error) {
 			break
 		}
 		labelBuff := make([]byte, lengthBuf[0])
-		_, err = r.Read(labelBuff)
+		_, err = io.ReadFull(cur, labelBuff)
 		if err != nil {
-			return DomainName{}, err
+			return DomainName{}, fmt.Errorf("reading label of %d bytes at %d: %w", len(labelBuff), cur.offset(), io.ErrUnexpectedEOF)
 		}
 		newLabel := Label{
 			length: uint16(lengthBuf[0]),
@@ -140,13 +123,88 @@ func parseDomainName(r io.Reader) (DomainName, error) {
 	}, nil
 }
 
-func (dn DomainName) len() int {
-	length := 0
+// String returns the name in dotted form without the trailing dot, the
+// root being the empty string.
+func (dn DomainName) String() string {
+	words := make([]string, 0, len(dn.labels))
 	for _, l := range dn.labels {
-		length += l.len()
+		if l.isZero() {
+			continue
+		}
+		words = append(words, l.str)
 	}
+	return strings.Join(words, ".")
+}
 
-	return length
+// fqdn returns the name in presentation format: dotted with the trailing
+// dot, and with dots inside labels, spaces, quotes, semicolons, backslashes
+// and unprintable bytes escaped.
+func (dn DomainName) fqdn() string {
+	var sb strings.Builder
+	for _, l := range dn.labels {
+		if l.isZero() {
+			continue
+		}
+		for i := 0; i < len(l.str); i++ {
+			c := l.str[i]
+			switch {
+			case c == '.':
+				sb.WriteString(`\.`)
+			case c <= ' ' || c > '~' || c == '"' || c == ';' || c == '\\':
+				fmt.Fprintf(&sb, "\\%03d", c)
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		sb.WriteByte('.')
+	}
+	if sb.Len() == 0 {
+		return "."
+	}
+	return sb.String()
+}
+
+// parseName reads a name in presentation format, the reverse of fqdn,
+// failing for names that can't be encoded.
+func parseName(s string) (DomainName, error) {
+	labels := []Label{}
+	var label strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.':
+			if label.Len() == 0 && (i != len(s)-1 || len(labels) != 0) {
+				return DomainName{}, fmt.Errorf("name %q has an empty label", s)
+			}
+			if label.Len() != 0 {
+				labels = append(labels, Label{length: uint16(label.Len()), str: label.String()})
+				label.Reset()
+			}
+		case c == '\\' && i+3 < len(s) && isDigits(s[i+1:i+4]):
+			n, _ := strconv.Atoi(s[i+1 : i+4])
+			if n > 255 {
+				return DomainName{}, fmt.Errorf("escape \\%s in name %q is out of range", s[i+1:i+4], s)
+			}
+			label.WriteByte(byte(n))
+			i += 3
+		case c == '\\':
+			if i+1 == len(s) {
+				return DomainName{}, fmt.Errorf("name %q ends in a backslash", s)
+			}
+			i++
+			label.WriteByte(s[i])
+		default:
+			label.WriteByte(c)
+		}
+	}
+	if label.Len() != 0 {
+		labels = append(labels, Label{length: uint16(label.Len()), str: label.String()})
+	}
+	dn := DomainName{labels: append(labels, Label{})}
+	if err := dn.check(); err != nil {
+		return DomainName{}, err
+	}
+	return dn, nil
 }
 
 func (dn DomainName) Bytes() []byte {
@@ -156,3 +214,34 @@ func (dn DomainName) Bytes() []byte {
 	}
 	return b.Bytes()
 }
+
+// check tells why dn can't be written in wire format, if it can't: labels
+// are at most 63 bytes long, names 255, and only the root label is empty.
+func (dn DomainName) check() error {
+	length := 0
+	for i, l := range dn.labels {
+		if l.isZero() && i != len(dn.labels)-1 {
+			return fmt.Errorf("name %q has an empty label", dn.String())
+		}
+		if l.length > maxLabelLength {
+			return fmt.Errorf("label %q is %d bytes long, longer than %d", l.str, l.length, maxLabelLength)
+		}
+		length += 1 + int(l.length)
+	}
+	if length > maxNameLength {
+		return fmt.Errorf("name %q is %d bytes long, longer than %d", dn.String(), length, maxNameLength)
+	}
+	return nil
+}
+
+// inZone reports whether name is zone or below it, names being compared
+// case insensitively with or without their trailing dot.
+func inZone(name string, zone string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	return zone == "" || name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+func sameName(a string, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
@@ -0,0 +1,383 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+const (
+	// EDNS option codes
+	EDNSOptionNSID          uint16 = 3
+	EDNSOptionClientSubnet  uint16 = 8
+	EDNSOptionCookie        uint16 = 10
+	EDNSOptionPadding       uint16 = 12
+	EDNSOptionExtendedError uint16 = 15
+
+	// DefaultEDNSBufferSize is the UDP payload size advertised by default,
+	// small enough to avoid IP fragmentation on common paths.
+	DefaultEDNSBufferSize uint16 = 1232
+
+	ednsFlagDO uint16 = 1 << 15
+)
+
+// EDNS is the EDNS(0) information a message carries in its OPT
+// pseudo-record, whose class holds the UDP payload size and whose TTL holds
+// the extended rcode, version and flags.
+type EDNS struct {
+	UDPSize       uint16
+	ExtendedRCode uint8
+	Version       uint8
+	DO            bool
+	Z             uint16
+	Options       []EDNSOption
+}
+
+func (e EDNS) record() ResourceRecord {
+	flags := e.Z &^ ednsFlagDO
+	if e.DO {
+		flags |= ednsFlagDO
+	}
+	return ResourceRecord{
+		Name:  NewDomainName("."),
+		Type:  RecordTypeOPT,
+		Class: e.UDPSize,
+		TTL:   uint32(e.ExtendedRCode)<<24 | uint32(e.Version)<<16 | uint32(flags),
+		RData: OPT{Options: e.Options},
+	}
+}
+
+func ednsOf(a ResourceRecord) EDNS {
+	flags := uint16(a.TTL)
+	opt, _ := a.RData.(OPT)
+	return EDNS{
+		UDPSize:       a.Class,
+		ExtendedRCode: uint8(a.TTL >> 24),
+		Version:       uint8(a.TTL >> 16),
+		DO:            flags&ednsFlagDO != 0,
+		Z:             flags &^ ednsFlagDO,
+		Options:       opt.Options,
+	}
+}
+
+// EDNS returns the EDNS(0) information of m, if it has an OPT record.
+func (m Message) EDNS() (EDNS, bool) {
+	for _, a := range m.Additional {
+		if a.Type == RecordTypeOPT {
+			return ednsOf(a), true
+		}
+	}
+	return EDNS{}, false
+}
+
+// SetEDNS replaces the OPT record of m with one holding e, adding it when
+// m has none.
+func (m *Message) SetEDNS(e EDNS) {
+	m.RemoveEDNS()
+	m.Additional = append(m.Additional, e.record())
+}
+
+// RemoveEDNS drops the OPT record of m.
+func (m *Message) RemoveEDNS() {
+	additional := []ResourceRecord{}
+	for _, a := range m.Additional {
+		if a.Type != RecordTypeOPT {
+			additional = append(additional, a)
+		}
+	}
+	m.Additional = additional
+}
+
+// RCode returns the response code of m, extended by the upper bits held
+// in its OPT record.
+func (m Message) RCode() RCode {
+	rcode := m.Header.RCode()
+	if e, ok := m.EDNS(); ok {
+		rcode |= RCode(e.ExtendedRCode) << 4
+	}
+	return rcode
+}
+
+// SetRCode sets the response code of m, keeping the bits that don't fit the
+// header in its OPT record, which is added for them when missing.
+func (m *Message) SetRCode(rcode RCode) {
+	m.Header.SetRCode(rcode)
+	e, ok := m.EDNS()
+	if !ok && rcode <= RCode(rcodeMask) {
+		return
+	}
+	if !ok {
+		e.UDPSize = DefaultEDNSBufferSize
+	}
+	e.ExtendedRCode = uint8(rcode >> 4)
+	m.SetEDNS(e)
+}
+
+// WithEDNS adds an OPT record advertising udpSize and setting the DNSSEC OK
+// bit when do is set.
+func WithEDNS(udpSize uint16, do bool) func(*Message) {
+	return func(r *Message) {
+		e, _ := r.EDNS()
+		e.UDPSize = udpSize
+		e.DO = do
+		r.SetEDNS(e)
+	}
+}
+
+// WithEDNSOptions adds opts to the OPT record of the message, which is
+// created with the default UDP size when missing.
+func WithEDNSOptions(opts ...EDNSOption) func(*Message) {
+	return func(r *Message) {
+		e, ok := r.EDNS()
+		if !ok {
+			e.UDPSize = DefaultEDNSBufferSize
+		}
+		e.Options = append(e.Options, opts...)
+		r.SetEDNS(e)
+	}
+}
+
+// OPT is the rdata of the OPT pseudo-record, a list of options.
+type OPT struct {
+	Options []EDNSOption
+}
+
+func unpackOPT(r wireReader, length int) (RData, error) {
+	opts := []EDNSOption{}
+	for consumed := 0; consumed < length; {
+		buf, err := read(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		code := binary.BigEndian.Uint16(buf[0:2])
+		data, err := read(r, int(binary.BigEndian.Uint16(buf[2:4])))
+		if err != nil {
+			return nil, fmt.Errorf("reading option %d: %w", code, err)
+		}
+		opt, err := unpackEDNSOption(code, data)
+		if err != nil {
+			return nil, fmt.Errorf("reading option %d: %w", code, err)
+		}
+		opts = append(opts, opt)
+		consumed += 4 + len(data)
+	}
+	return OPT{Options: opts}, nil
+}
+
+func (o OPT) Type() uint16 {
+	return RecordTypeOPT
+}
+
+func (o OPT) String() string {
+	strs := make([]string, 0, len(o.Options))
+	for _, opt := range o.Options {
+		strs = append(strs, opt.String())
+	}
+	return strings.Join(strs, "; ")
+}
+
+func (o OPT) pack(e *encoder) {
+	for _, opt := range o.Options {
+		e.uint16(opt.Code())
+		done := e.length()
+		e.bytes(opt.pack())
+		done()
+	}
+}
+
+// EDNSOption is an option of an OPT record. Options without a dedicated
+// type are kept as UnknownOption.
+type EDNSOption interface {
+	Code() uint16
+	String() string
+
+	pack() []byte
+}
+
+func unpackEDNSOption(code uint16, data []byte) (EDNSOption, error) {
+	switch code {
+	case EDNSOptionNSID:
+		return NSID{Data: data}, nil
+	case EDNSOptionClientSubnet:
+		return unpackClientSubnet(data)
+	case EDNSOptionCookie:
+		return unpackCookie(data)
+	case EDNSOptionPadding:
+		return Padding{Length: len(data)}, nil
+	case EDNSOptionExtendedError:
+		if len(data) < 2 {
+			return nil, fmt.Errorf("extended error is %d bytes long, shorter than 2", len(data))
+		}
+		return ExtendedError{
+			InfoCode:  binary.BigEndian.Uint16(data),
+			ExtraText: string(data[2:]),
+		}, nil
+	default:
+		return UnknownOption{OptionCode: code, Data: data}, nil
+	}
+}
+
+// NSID carries the identifier of the responding server, RFC 5001. Queries
+// ask for it with an empty NSID.
+type NSID struct {
+	Data []byte
+}
+
+func (n NSID) Code() uint16 {
+	return EDNSOptionNSID
+}
+
+func (n NSID) String() string {
+	return fmt.Sprintf("NSID: %s (%q)", hex.EncodeToString(n.Data), n.Data)
+}
+
+func (n NSID) pack() []byte {
+	return n.Data
+}
+
+// ClientSubnet is the EDNS Client Subnet option, RFC 7871.
+type ClientSubnet struct {
+	SourcePrefix uint8
+	ScopePrefix  uint8
+	Address      netip.Addr
+}
+
+func unpackClientSubnet(data []byte) (EDNSOption, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("client subnet is %d bytes long, shorter than 4", len(data))
+	}
+	family := binary.BigEndian.Uint16(data[0:2])
+	addr := data[4:]
+
+	var address netip.Addr
+	switch family {
+	case 1:
+		if len(addr) > 4 {
+			return nil, fmt.Errorf("client subnet has %d address bytes for ipv4", len(addr))
+		}
+		var b [4]byte
+		copy(b[:], addr)
+		address = netip.AddrFrom4(b)
+	case 2:
+		if len(addr) > 16 {
+			return nil, fmt.Errorf("client subnet has %d address bytes for ipv6", len(addr))
+		}
+		var b [16]byte
+		copy(b[:], addr)
+		address = netip.AddrFrom16(b)
+	default:
+		return nil, fmt.Errorf("unsupported client subnet family %d", family)
+	}
+
+	return ClientSubnet{
+		SourcePrefix: data[2],
+		ScopePrefix:  data[3],
+		Address:      address,
+	}, nil
+}
+
+func (c ClientSubnet) Code() uint16 {
+	return EDNSOptionClientSubnet
+}
+
+func (c ClientSubnet) String() string {
+	return fmt.Sprintf("CLIENT-SUBNET: %s/%d/%d", c.Address, c.SourcePrefix, c.ScopePrefix)
+}
+
+func (c ClientSubnet) pack() []byte {
+	family := uint16(2)
+	addr := c.Address.AsSlice()
+	if c.Address.Is4() {
+		family = 1
+	}
+	b := UInt16ToByteSlice(family)
+	b = append(b, c.SourcePrefix, c.ScopePrefix)
+	return append(b, addr[:min((int(c.SourcePrefix)+7)/8, len(addr))]...)
+}
+
+// Cookie is the DNS cookie option, RFC 7873, made of an 8 byte client
+// cookie and, in responses, a server cookie of 8 to 32 bytes.
+type Cookie struct {
+	Client []byte
+	Server []byte
+}
+
+func unpackCookie(data []byte) (EDNSOption, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("cookie is %d bytes long, shorter than 8", len(data))
+	}
+	return Cookie{
+		Client: data[:8],
+		Server: data[8:],
+	}, nil
+}
+
+func (c Cookie) Code() uint16 {
+	return EDNSOptionCookie
+}
+
+func (c Cookie) String() string {
+	return fmt.Sprintf("COOKIE: %s%s", hex.EncodeToString(c.Client), hex.EncodeToString(c.Server))
+}
+
+func (c Cookie) pack() []byte {
+	return append(append([]byte{}, c.Client...), c.Server...)
+}
+
+// Padding is the padding option, RFC 7830, of Length zero bytes.
+type Padding struct {
+	Length int
+}
+
+func (p Padding) Code() uint16 {
+	return EDNSOptionPadding
+}
+
+func (p Padding) String() string {
+	return fmt.Sprintf("PADDING: %d bytes", p.Length)
+}
+
+func (p Padding) pack() []byte {
+	return make([]byte, p.Length)
+}
+
+// ExtendedError is the extended DNS error option, RFC 8914.
+type ExtendedError struct {
+	InfoCode  uint16
+	ExtraText string
+}
+
+func (e ExtendedError) Code() uint16 {
+	return EDNSOptionExtendedError
+}
+
+func (e ExtendedError) String() string {
+	if e.ExtraText == "" {
+		return fmt.Sprintf("EDE: %d", e.InfoCode)
+	}
+	return fmt.Sprintf("EDE: %d: %q", e.InfoCode, e.ExtraText)
+}
+
+func (e ExtendedError) pack() []byte {
+	return append(UInt16ToByteSlice(e.InfoCode), e.ExtraText...)
+}
+
+// UnknownOption is an option without a dedicated type.
+type UnknownOption struct {
+	OptionCode uint16
+	Data       []byte
+}
+
+func (u UnknownOption) Code() uint16 {
+	return u.OptionCode
+}
+
+func (u UnknownOption) String() string {
+	return fmt.Sprintf("OPT%d: %s", u.OptionCode, hex.EncodeToString(u.Data))
+}
+
+func (u UnknownOption) pack() []byte {
+	return u.Data
+}
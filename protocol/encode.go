@@ -0,0 +1,161 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// maxPointerOffset is the largest offset a compression pointer can hold.
+const maxPointerOffset = 0x3fff
+
+// encoder writes a message front to back, remembering where every name
+// suffix was written so that later names can point back to it. err holds
+// the first thing that didn't fit the wire format, after which buf is of
+// no use.
+type encoder struct {
+	buf      []byte
+	compress bool
+	names    map[string]int
+	err      error
+}
+
+// newEncoder returns an encoder that emits compression pointers when
+// compress is set.
+func newEncoder(compress bool) *encoder {
+	return &encoder{
+		buf:      []byte{},
+		compress: compress,
+		names:    map[string]int{},
+	}
+}
+
+func (e *encoder) uint16(u uint16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, u)
+}
+
+func (e *encoder) uint32(u uint32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, u)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+// ipv4 writes addr, which has to be an IPv4 address.
+func (e *encoder) ipv4(addr netip.Addr) {
+	if !addr.Is4() {
+		e.fail(fmt.Errorf("%s is not an IPv4 address", addr))
+		return
+	}
+	b := addr.As4()
+	e.bytes(b[:])
+}
+
+// ipv6 writes addr, which has to be an IPv6 address.
+func (e *encoder) ipv6(addr netip.Addr) {
+	if !addr.Is6() {
+		e.fail(fmt.Errorf("%s is not an IPv6 address", addr))
+		return
+	}
+	b := addr.As16()
+	e.bytes(b[:])
+}
+
+// characterString writes s preceded by its length in a byte.
+func (e *encoder) characterString(s string) {
+	if len(s) > maxStringLength {
+		e.fail(fmt.Errorf("character string of %d bytes is longer than %d", len(s), maxStringLength))
+	}
+	e.bytes(encodeString(s))
+}
+
+// length writes a placeholder for the length in two bytes of what is
+// written next, returning the func that fills it in once that is done.
+func (e *encoder) length() func() {
+	at := len(e.buf)
+	e.uint16(0)
+	return func() {
+		n := len(e.buf) - at - 2
+		if n > 0xffff {
+			e.fail(fmt.Errorf("%d bytes of data are more than a length of two bytes holds", n))
+		}
+		binary.BigEndian.PutUint16(e.buf[at:], uint16(n))
+	}
+}
+
+// name writes dn, replacing its longest suffix that was written before
+// with a pointer when compress is set. Names of record types that aren't
+// well known per RFC 3597 are written with compress unset, but still serve
+// as targets for later pointers.
+func (e *encoder) name(dn DomainName, compress bool) {
+	if err := dn.check(); err != nil {
+		e.fail(err)
+	}
+	for i, l := range dn.labels {
+		if l.isZero() {
+			break
+		}
+
+		key := suffixKey(dn.labels[i:])
+		if offset, ok := e.names[key]; ok && compress && e.compress {
+			e.uint16(offsetFlagExcess | uint16(offset))
+			return
+		}
+		if len(e.buf) <= maxPointerOffset {
+			e.names[key] = len(e.buf)
+		}
+		e.bytes(l.Bytes())
+	}
+	e.buf = append(e.buf, 0)
+}
+
+func (e *encoder) header(h Header) {
+	e.uint16(h.ID)
+	e.uint16(h.Flags)
+	e.uint16(h.QDCount)
+	e.uint16(h.ANCount)
+	e.uint16(h.NSCount)
+	e.uint16(h.ARCount)
+}
+
+func (e *encoder) question(q Question) {
+	e.name(q.QName, true)
+	e.uint16(q.QType)
+	e.uint16(q.QClass)
+}
+
+func (e *encoder) record(a ResourceRecord) {
+	e.name(a.Name, true)
+	e.uint16(a.Type)
+	e.uint16(a.Class)
+	e.uint32(a.TTL)
+
+	done := e.length()
+	a.RData.pack(e)
+	done()
+}
+
+// suffixKey identifies the name made of labels, which compare case
+// insensitively.
+func suffixKey(labels []Label) string {
+	var sb strings.Builder
+	for _, l := range labels {
+		sb.WriteByte(byte(l.length))
+		for i := 0; i < len(l.str); i++ {
+			c := l.str[i]
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,43 @@
+package protocol
+
+import "errors"
+
+var (
+	// ErrTimeout is returned when a nameserver did not answer in time or the
+	// context deadline expired while waiting for a response.
+	ErrTimeout = errors.New("timed out waiting for response")
+
+	// ErrServerFailure is returned when a nameserver answered with SERVFAIL
+	// or another rcode that doesn't carry an answer.
+	ErrServerFailure = errors.New("server failure")
+
+	// ErrNXDomain is returned when the queried name does not exist.
+	ErrNXDomain = errors.New("non-existent domain")
+
+	// ErrNoData is returned when the queried name exists but has no records
+	// of the queried type.
+	ErrNoData = errors.New("no records of requested type")
+
+	// ErrMalformedResponse is returned when a response could not be parsed.
+	ErrMalformedResponse = errors.New("malformed response")
+
+	// ErrMismatchedResponse is returned when a response doesn't answer the
+	// query it was received for, as spoofed responses wouldn't.
+	ErrMismatchedResponse = errors.New("response does not match query")
+
+	// ErrLameDelegation is returned when a nameserver neither answers nor
+	// refers to a server that can be queried next.
+	ErrLameDelegation = errors.New("lame delegation")
+
+	// ErrCNAMELoop is returned when following CNAMEs leads back to a name
+	// that was already visited.
+	ErrCNAMELoop = errors.New("cname loop")
+
+	// ErrNotConfirmed is returned when none of the names of an address
+	// resolve back to it.
+	ErrNotConfirmed = errors.New("no name confirmed by forward lookup")
+
+	// ErrCNAMEChainTooLong is returned when more CNAMEs than the resolver
+	// allows have to be followed.
+	ErrCNAMEChainTooLong = errors.New("cname chain too long")
+)
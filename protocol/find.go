@@ -1,94 +1,86 @@
 package protocol
 
 import (
-	"bufio"
-	"fmt"
-	"log"
-	"net"
+	"context"
+	"net/netip"
+	"time"
 )
 
-func writeReqReadResp(rw *bufio.ReadWriter, req Message) (Message, error) {
-	n, err := rw.Write(req.Bytes())
-	if err != nil {
-		return Message{}, err
-	}
-	if n != len(req.Bytes()) {
-		return Message{}, fmt.Errorf("wrote %d bytes but message is %d bytes long", n, len(req.Bytes()))
-	}
-	err = rw.Flush()
-	if err != nil {
-		return Message{}, err
-	}
+const maxReferrals = 32
 
-	resp, err := Parse(rw)
+// Result is the outcome of resolving a name. Chain holds the CNAMEs that
+// were followed from Name, in order, and Answers the records of Type owned
+// by the last name of the chain.
+type Result struct {
+	Name    string
+	Type    uint16
+	Chain   []ResourceRecord
+	Answers []ResourceRecord
+}
 
-	return resp, nil
+// Addrs returns the addresses held by the A and AAAA records of the
+// answer.
+func (r Result) Addrs() []netip.Addr {
+	addrs := make([]netip.Addr, 0, len(r.Answers))
+	for _, rr := range r.Answers {
+		if addr, ok := rr.Addr(); ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
 }
 
-func Find(target string) []string {
-	var (
-		ip   = net.IPv4(198, 41, 0, 4)
-		port = 53
-	)
-	for {
-		req := NewMessage(
-			WithID(22),
-			WithQuestion(target, 1, 1),
-		)
+// Addresses returns the addresses of the answer in their textual form.
+func (r Result) Addresses() []string {
+	addrs := []string{}
+	for _, addr := range r.Addrs() {
+		addrs = append(addrs, addr.String())
+	}
+	return addrs
+}
 
-		addr := &net.UDPAddr{
-			IP:   ip,
-			Port: port,
-			Zone: "",
-		}
+// TraceStep is a query sent while resolving. Zone is the zone Server was
+// asked as a nameserver of, the root being the empty string, and Attempt
+// counts the rounds over the zone's nameservers from 1. Response is only
+// set when Err is nil.
+type TraceStep struct {
+	Server   netip.AddrPort
+	Zone     string
+	Attempt  int
+	Query    Message
+	Response Message
+	Latency  time.Duration
+	Err      error
+}
 
-		conn, err := net.DialUDP("udp", nil, addr)
-		if err != nil {
-			log.Fatalf("error dialing %s", err)
-		}
-		defer conn.Close()
-		rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+// DefaultResolver is used by Resolve and Find.
+var DefaultResolver = NewResolver()
 
-		resp, err := writeReqReadResp(rw, req)
-		if err != nil {
-			log.Fatalf("error while sending/reading request %s", err)
-		}
+// Resolve resolves name using DefaultResolver.
+func Resolve(ctx context.Context, name string, qtype uint16) (Result, error) {
+	return DefaultResolver.Resolve(ctx, name, qtype)
+}
+
+// ReverseLookup resolves the names of addr using DefaultResolver.
+func ReverseLookup(ctx context.Context, addr netip.Addr) ([]string, error) {
+	return DefaultResolver.ReverseLookup(ctx, addr)
+}
 
-	outer:
-		for {
-			var (
-				ans          = make([]string, 0)
-				scopedTarget = target
-			)
+// Find resolves the addresses of target using DefaultResolver. qtypes
+// selects the families, RecordTypeA and/or RecordTypeAAAA, and defaults to
+// IPv4 only.
+func Find(target string, qtypes ...uint16) ([]string, error) {
+	if len(qtypes) == 0 {
+		qtypes = []uint16{RecordTypeA}
+	}
+	addrs, err := DefaultResolver.LookupAddrs(context.Background(), target, qtypes...)
+	if err != nil {
+		return nil, err
+	}
 
-			// result was found
-			if len(resp.Answers) != 0 {
-				for _, rr := range resp.Answers {
-					switch rr.Type {
-					case RecordTypeAAAA:
-						break
-					case RecordTypeCNAME:
-						if scopedTarget == resp.fullNameOfRecord(rr) {
-							scopedTarget = resp.fullRDataOfRecord(rr)
-						}
-					case RecordTypeA:
-						ans = append(ans, resp.fullRDataOfRecord(rr))
-					}
-				}
-				return ans
-			} else {
-				for _, rr := range resp.Authority {
-					additionals := resp.RecordsOfDomainName(resp.fullRDataOfRecord(rr))
-					if len(additionals) != 0 {
-						for _, additional := range additionals {
-							if additional.Type == RecordTypeA {
-								ip = net.IP(resp.formattedRDataOf(additional))
-								break outer
-							}
-						}
-					}
-				}
-			}
-		}
+	result := []string{}
+	for _, addr := range addrs {
+		result = append(result, addr.String())
 	}
+	return result, nil
 }
@@ -0,0 +1,185 @@
+package protocol
+
+import "strconv"
+
+// header flag bits, counting from the most significant bit of Flags
+const (
+	flagQR uint16 = 1 << 15
+	flagAA uint16 = 1 << 10
+	flagTC uint16 = 1 << 9
+	flagRD uint16 = 1 << 8
+	flagRA uint16 = 1 << 7
+	flagAD uint16 = 1 << 5
+	flagCD uint16 = 1 << 4
+
+	opcodeShift        = 11
+	opcodeMask  uint16 = 0xf << opcodeShift
+	rcodeMask   uint16 = 0xf
+)
+
+// Opcode is the kind of query a message holds.
+type Opcode uint8
+
+const (
+	OpcodeQuery  Opcode = 0
+	OpcodeIQuery Opcode = 1
+	OpcodeStatus Opcode = 2
+	OpcodeNotify Opcode = 4
+	OpcodeUpdate Opcode = 5
+	OpcodeDSO    Opcode = 6
+)
+
+var opcodeNames = map[Opcode]string{
+	OpcodeQuery:  "QUERY",
+	OpcodeIQuery: "IQUERY",
+	OpcodeStatus: "STATUS",
+	OpcodeNotify: "NOTIFY",
+	OpcodeUpdate: "UPDATE",
+	OpcodeDSO:    "DSO",
+}
+
+func (o Opcode) String() string {
+	if name, ok := opcodeNames[o]; ok {
+		return name
+	}
+	return "OPCODE" + strconv.Itoa(int(o))
+}
+
+// RCode is the response code of a message.
+type RCode uint16
+
+const (
+	RCodeNoError  RCode = 0
+	RCodeFormErr  RCode = 1
+	RCodeServFail RCode = 2
+	RCodeNXDomain RCode = 3
+	RCodeNotImp   RCode = 4
+	RCodeRefused  RCode = 5
+	RCodeYXDomain RCode = 6
+	RCodeYXRRSet  RCode = 7
+	RCodeNXRRSet  RCode = 8
+	RCodeNotAuth  RCode = 9
+	RCodeNotZone  RCode = 10
+
+	// extended with the upper bits from the OPT record
+	RCodeBadVers   RCode = 16
+	RCodeBadCookie RCode = 23
+)
+
+var rcodeNames = map[RCode]string{
+	RCodeNoError:  "NOERROR",
+	RCodeFormErr:  "FORMERR",
+	RCodeServFail: "SERVFAIL",
+	RCodeNXDomain: "NXDOMAIN",
+	RCodeNotImp:   "NOTIMP",
+	RCodeRefused:  "REFUSED",
+	RCodeYXDomain: "YXDOMAIN",
+	RCodeYXRRSet:  "YXRRSET",
+	RCodeNXRRSet:  "NXRRSET",
+	RCodeNotAuth:  "NOTAUTH",
+	RCodeNotZone:  "NOTZONE",
+
+	RCodeBadVers:   "BADVERS",
+	RCodeBadCookie: "BADCOOKIE",
+}
+
+func (r RCode) String() string {
+	if name, ok := rcodeNames[r]; ok {
+		return name
+	}
+	return "RCODE" + strconv.Itoa(int(r))
+}
+
+func (h Header) flag(mask uint16) bool {
+	return h.Flags&mask != 0
+}
+
+func (h *Header) setFlag(mask uint16, on bool) {
+	if on {
+		h.Flags |= mask
+	} else {
+		h.Flags &^= mask
+	}
+}
+
+// QR reports whether the message is a response.
+func (h Header) QR() bool {
+	return h.flag(flagQR)
+}
+
+func (h *Header) SetQR(on bool) {
+	h.setFlag(flagQR, on)
+}
+
+func (h Header) Opcode() Opcode {
+	return Opcode((h.Flags & opcodeMask) >> opcodeShift)
+}
+
+func (h *Header) SetOpcode(o Opcode) {
+	h.Flags = h.Flags&^opcodeMask | uint16(o)<<opcodeShift&opcodeMask
+}
+
+// AA reports whether the responding server is authoritative for the
+// answer.
+func (h Header) AA() bool {
+	return h.flag(flagAA)
+}
+
+func (h *Header) SetAA(on bool) {
+	h.setFlag(flagAA, on)
+}
+
+// TC reports whether the message was truncated to fit the transport.
+func (h Header) TC() bool {
+	return h.flag(flagTC)
+}
+
+func (h *Header) SetTC(on bool) {
+	h.setFlag(flagTC, on)
+}
+
+// RD reports whether recursion is desired.
+func (h Header) RD() bool {
+	return h.flag(flagRD)
+}
+
+func (h *Header) SetRD(on bool) {
+	h.setFlag(flagRD, on)
+}
+
+// RA reports whether the server offers recursion.
+func (h Header) RA() bool {
+	return h.flag(flagRA)
+}
+
+func (h *Header) SetRA(on bool) {
+	h.setFlag(flagRA, on)
+}
+
+// AD reports whether the data was authenticated by DNSSEC.
+func (h Header) AD() bool {
+	return h.flag(flagAD)
+}
+
+func (h *Header) SetAD(on bool) {
+	h.setFlag(flagAD, on)
+}
+
+// CD reports whether DNSSEC checking is disabled.
+func (h Header) CD() bool {
+	return h.flag(flagCD)
+}
+
+func (h *Header) SetCD(on bool) {
+	h.setFlag(flagCD, on)
+}
+
+// RCode returns the four bit response code of the header, see
+// Message.RCode for the extended one.
+func (h Header) RCode() RCode {
+	return RCode(h.Flags & rcodeMask)
+}
+
+func (h *Header) SetRCode(r RCode) {
+	h.Flags = h.Flags&^rcodeMask | uint16(r)&rcodeMask
+}
@@ -0,0 +1,210 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// The JSON forms below follow RFC 8427. Records carry their rdata both as
+// RDATAHEX and, in presentation format, as rdata followed by the type
+// mnemonic, such as rdataMX. Decoding prefers RDATAHEX.
+
+type jsonMessage struct {
+	ID            uint16           `json:"ID"`
+	QR            bool             `json:"QR"`
+	Opcode        Opcode           `json:"Opcode"`
+	AA            bool             `json:"AA"`
+	TC            bool             `json:"TC"`
+	RD            bool             `json:"RD"`
+	RA            bool             `json:"RA"`
+	AD            bool             `json:"AD"`
+	CD            bool             `json:"CD"`
+	RCODE         RCode            `json:"RCODE"`
+	QDCOUNT       uint16           `json:"QDCOUNT"`
+	ANCOUNT       uint16           `json:"ANCOUNT"`
+	NSCOUNT       uint16           `json:"NSCOUNT"`
+	ARCOUNT       uint16           `json:"ARCOUNT"`
+	QuestionRRs   []Question       `json:"questionRRs"`
+	AnswerRRs     []ResourceRecord `json:"answerRRs"`
+	AuthorityRRs  []ResourceRecord `json:"authorityRRs"`
+	AdditionalRRs []ResourceRecord `json:"additionalRRs"`
+}
+
+// MarshalJSON encodes the header flags as members of their own and the
+// rcode of the header alone, the rest of an extended rcode staying in
+// the OPT record.
+func (m Message) MarshalJSON() ([]byte, error) {
+	h := m.Header
+	return json.Marshal(jsonMessage{
+		ID:            h.ID,
+		QR:            h.QR(),
+		Opcode:        h.Opcode(),
+		AA:            h.AA(),
+		TC:            h.TC(),
+		RD:            h.RD(),
+		RA:            h.RA(),
+		AD:            h.AD(),
+		CD:            h.CD(),
+		RCODE:         h.RCode(),
+		QDCOUNT:       uint16(len(m.Questions)),
+		ANCOUNT:       uint16(len(m.Answers)),
+		NSCOUNT:       uint16(len(m.Authority)),
+		ARCOUNT:       uint16(len(m.Additional)),
+		QuestionRRs:   nonNil(m.Questions),
+		AnswerRRs:     nonNil(m.Answers),
+		AuthorityRRs:  nonNil(m.Authority),
+		AdditionalRRs: nonNil(m.Additional),
+	})
+}
+
+func (m *Message) UnmarshalJSON(b []byte) error {
+	var j jsonMessage
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+
+	h := Header{
+		ID:      j.ID,
+		QDCount: j.QDCOUNT,
+		ANCount: j.ANCOUNT,
+		NSCount: j.NSCOUNT,
+		ARCount: j.ARCOUNT,
+	}
+	h.SetQR(j.QR)
+	h.SetOpcode(j.Opcode)
+	h.SetAA(j.AA)
+	h.SetTC(j.TC)
+	h.SetRD(j.RD)
+	h.SetRA(j.RA)
+	h.SetAD(j.AD)
+	h.SetCD(j.CD)
+	h.SetRCode(j.RCODE)
+
+	*m = Message{
+		Header:     h,
+		Questions:  nonNil(j.QuestionRRs),
+		Answers:    nonNil(j.AnswerRRs),
+		Authority:  nonNil(j.AuthorityRRs),
+		Additional: nonNil(j.AdditionalRRs),
+	}
+	return nil
+}
+
+type jsonQuestion struct {
+	NAME      string `json:"NAME"`
+	TYPE      uint16 `json:"TYPE"`
+	TYPEname  string `json:"TYPEname,omitempty"`
+	CLASS     uint16 `json:"CLASS"`
+	CLASSname string `json:"CLASSname,omitempty"`
+}
+
+func (q Question) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQuestion{
+		NAME:      q.QName.fqdn(),
+		TYPE:      q.QType,
+		TYPEname:  TypeString(q.QType),
+		CLASS:     q.QClass,
+		CLASSname: ClassString(q.QClass),
+	})
+}
+
+func (q *Question) UnmarshalJSON(b []byte) error {
+	var j jsonQuestion
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	name, err := parseName(j.NAME)
+	if err != nil {
+		return fmt.Errorf("reading NAME: %w", err)
+	}
+	*q = Question{
+		QName:  name,
+		QType:  j.TYPE,
+		QClass: j.CLASS,
+	}
+	return nil
+}
+
+// MarshalJSON encodes the record as an object whose rdata member is named
+// after the type, which rules out a struct.
+func (a ResourceRecord) MarshalJSON() ([]byte, error) {
+	e := newEncoder(false)
+	a.RData.pack(e)
+	if e.err != nil {
+		return nil, fmt.Errorf("encoding %s rdata: %w", TypeString(a.Type), e.err)
+	}
+
+	return json.Marshal(map[string]any{
+		"NAME":                       a.Name.fqdn(),
+		"TYPE":                       a.Type,
+		"TYPEname":                   TypeString(a.Type),
+		"CLASS":                      a.Class,
+		"CLASSname":                  ClassString(a.Class),
+		"TTL":                        a.TTL,
+		"RDLENGTH":                   len(e.buf),
+		"RDATAHEX":                   strings.ToUpper(hex.EncodeToString(e.buf)),
+		"rdata" + TypeString(a.Type): a.RData.String(),
+	})
+}
+
+func (a *ResourceRecord) UnmarshalJSON(b []byte) error {
+	var j struct {
+		NAME     string  `json:"NAME"`
+		TYPE     uint16  `json:"TYPE"`
+		CLASS    uint16  `json:"CLASS"`
+		TTL      uint32  `json:"TTL"`
+		RDATAHEX *string `json:"RDATAHEX"`
+	}
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+
+	var rdata RData
+	if j.RDATAHEX != nil {
+		buf, err := hex.DecodeString(*j.RDATAHEX)
+		if err != nil {
+			return fmt.Errorf("decoding RDATAHEX: %w", err)
+		}
+		if rdata, err = unpackWholeRData(j.TYPE, buf); err != nil {
+			return fmt.Errorf("decoding RDATAHEX: %w", err)
+		}
+	} else {
+		var members map[string]json.RawMessage
+		if err := json.Unmarshal(b, &members); err != nil {
+			return err
+		}
+		member := "rdata" + TypeString(j.TYPE)
+		var s string
+		if err := json.Unmarshal(members[member], &s); err != nil {
+			return fmt.Errorf("reading %s: %w", member, err)
+		}
+		var err error
+		if rdata, err = parseRData(j.TYPE, s); err != nil {
+			return fmt.Errorf("reading %s: %w", member, err)
+		}
+	}
+
+	name, err := parseName(j.NAME)
+	if err != nil {
+		return fmt.Errorf("reading NAME: %w", err)
+	}
+	*a = ResourceRecord{
+		Name:  name,
+		Type:  j.TYPE,
+		Class: j.CLASS,
+		TTL:   j.TTL,
+		RData: rdata,
+	}
+	return nil
+}
+
+// nonNil returns s or, when nil, an empty slice so that it encodes as an
+// empty array.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
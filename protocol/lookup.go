@@ -0,0 +1,138 @@
+package protocol
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+// LookupMX resolves the mail exchangers of name, ordered by preference.
+func (r *Resolver) LookupMX(ctx context.Context, name string) ([]MX, error) {
+	mxs, err := lookup[MX](ctx, r, name, RecordTypeMX)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(mxs, func(a, b MX) int {
+		return cmp.Compare(a.Preference, b.Preference)
+	})
+	return mxs, nil
+}
+
+// LookupTXT resolves the TXT records of name. The strings of each record
+// are joined, the way SPF and most verification schemes read them.
+func (r *Resolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	txts, err := lookup[TXT](ctx, r, name, RecordTypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, 0, len(txts))
+	for _, txt := range txts {
+		strs = append(strs, strings.Join(txt.Strings, ""))
+	}
+	return strs, nil
+}
+
+// LookupSOA resolves the SOA record of the zone name is the origin of.
+func (r *Resolver) LookupSOA(ctx context.Context, name string) (SOA, error) {
+	soas, err := lookup[SOA](ctx, r, name, RecordTypeSOA)
+	if err != nil {
+		return SOA{}, err
+	}
+	return soas[0], nil
+}
+
+// LookupPTR resolves the names name points to, such as the ones returned
+// by ReverseName.
+func (r *Resolver) LookupPTR(ctx context.Context, name string) ([]string, error) {
+	ptrs, err := lookup[PTR](ctx, r, name, RecordTypePTR)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]string, 0, len(ptrs))
+	for _, ptr := range ptrs {
+		hosts = append(hosts, ptr.Host.String())
+	}
+	return hosts, nil
+}
+
+// LookupSRV resolves the endpoints of service over proto at name, such as
+// "sip", "tcp" and "example.com" for _sip._tcp.example.com, in the order
+// they should be tried in according to OrderSRV. name is looked up as is
+// when service and proto are empty.
+func (r *Resolver) LookupSRV(ctx context.Context, service, proto, name string) ([]SRV, error) {
+	if service != "" || proto != "" {
+		name = "_" + service + "._" + proto + "." + name
+	}
+	srvs, err := lookup[SRV](ctx, r, name, RecordTypeSRV)
+	if err != nil {
+		return nil, err
+	}
+	return OrderSRV(srvs), nil
+}
+
+// OrderSRV returns srvs in the order RFC 2782 has clients try them in:
+// by priority, lowest first, and within a priority at random, each record
+// being picked with a chance proportional to its weight.
+func OrderSRV(srvs []SRV) []SRV {
+	byPriority := slices.Clone(srvs)
+	slices.SortStableFunc(byPriority, func(a, b SRV) int {
+		return cmp.Compare(a.Priority, b.Priority)
+	})
+
+	ordered := make([]SRV, 0, len(srvs))
+	for len(byPriority) != 0 {
+		n := 1
+		for n < len(byPriority) && byPriority[n].Priority == byPriority[0].Priority {
+			n++
+		}
+		ordered = append(ordered, orderByWeight(byPriority[:n])...)
+		byPriority = byPriority[n:]
+	}
+	return ordered
+}
+
+// orderByWeight orders srvs of the same priority with the weighted
+// selection of RFC 2782, records of weight 0 being placed first so that
+// they have a small chance of being picked.
+func orderByWeight(srvs []SRV) []SRV {
+	left := slices.Clone(srvs)
+	slices.SortStableFunc(left, func(a, b SRV) int {
+		return cmp.Compare(min(a.Weight, 1), min(b.Weight, 1))
+	})
+
+	ordered := make([]SRV, 0, len(srvs))
+	for len(left) != 0 {
+		sum := 0
+		for _, srv := range left {
+			sum += int(srv.Weight)
+		}
+		pick, i := rand.IntN(sum+1), 0
+		for running := int(left[0].Weight); running < pick; running += int(left[i].Weight) {
+			i++
+		}
+		ordered = append(ordered, left[i])
+		left = slices.Delete(left, i, i+1)
+	}
+	return ordered
+}
+
+// lookup resolves name for qtype and returns the rdata of the answer.
+func lookup[T RData](ctx context.Context, r *Resolver, name string, qtype uint16) ([]T, error) {
+	result, err := r.Resolve(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	rdatas := []T{}
+	for _, rr := range result.Answers {
+		if rdata, ok := rr.RData.(T); ok {
+			rdatas = append(rdatas, rdata)
+		}
+	}
+	if len(rdatas) == 0 {
+		return nil, fmt.Errorf("%s %s: %w", name, TypeString(qtype), ErrNoData)
+	}
+	return rdatas, nil
+}
@@ -1,11 +1,11 @@
 package protocol
 
 import (
-	"bufio"
-	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"net/netip"
 	"strings"
 )
 
@@ -29,10 +29,6 @@ type Header struct {
 	ARCount uint16
 }
 
-func (h Header) offset() int {
-	return 2 + 2 + 2 + 2 + 2 + 2
-}
-
 func (h Header) WriteTo(w io.Writer) (int64, error) {
 	sum := 0
 	n, err := w.Write(UInt16ToByteSlice(h.ID))
@@ -75,11 +71,10 @@ type Question struct {
 	QClass uint16
 }
 
-func (q Question) offset() int {
-	return q.QName.len() + 2 + 2
-}
-
 func (q Question) WriteTo(w io.Writer) (int64, error) {
+	if err := q.QName.check(); err != nil {
+		return 0, err
+	}
 	sum := 0
 	n, err := w.Write(q.QName.Bytes())
 	sum += n
@@ -100,36 +95,39 @@ func (q Question) WriteTo(w io.Writer) (int64, error) {
 }
 
 type ResourceRecord struct {
-	Name     DomainName
-	Type     uint16
-	Class    uint16
-	TTL      uint32
-	RDLength uint16
-	RData    DomainName
+	Name  DomainName
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	RData RData
 }
 
-func (a ResourceRecord) offsetWithoutRData() int {
-	return a.Name.len() + 2 + 2 + 4 + 2
-}
-
-func (a ResourceRecord) offset() int {
-	return a.Name.len() + 2 + 2 + 4 + 2 + int(a.RDLength)
-}
-
-func (m Message) formattedRDataOf(a ResourceRecord) string {
-	switch a.Type {
-	case RecordTypeA:
-		return a.RData.labels[0].str
-	case RecordTypeCNAME:
-		return m.fullRDataOfRecord(a)
-	case RecordTypeAAAA:
-		return ""
+// Addr returns the address held by an A or AAAA record.
+func (a ResourceRecord) Addr() (netip.Addr, bool) {
+	switch rdata := a.RData.(type) {
+	case A:
+		return rdata.Addr, true
+	case AAAA:
+		return rdata.Addr, true
 	default:
-		return ""
+		return netip.Addr{}, false
 	}
 }
 
+// WriteTo writes a without compressing names. Nothing is written when a
+// can't be encoded.
 func (a ResourceRecord) WriteTo(w io.Writer) (int64, error) {
+	if err := a.Name.check(); err != nil {
+		return 0, err
+	}
+	e := newEncoder(false)
+	done := e.length()
+	a.RData.pack(e)
+	done()
+	if e.err != nil {
+		return 0, e.err
+	}
+
 	sum := 0
 	n, err := w.Write(a.Name.Bytes())
 	sum += n
@@ -151,12 +149,7 @@ func (a ResourceRecord) WriteTo(w io.Writer) (int64, error) {
 	if err != nil {
 		return int64(sum), err
 	}
-	n, err = w.Write(UInt16ToByteSlice(a.RDLength))
-	sum += n
-	if err != nil {
-		return int64(sum), err
-	}
-	n, err = w.Write(a.RData.Bytes())
+	n, err = w.Write(e.buf)
 	sum += n
 	if err != nil {
 		return int64(sum), err
@@ -176,22 +169,79 @@ type Message struct {
 
 type MessageOptsFunc func(*Message)
 
+// newID returns a random query id. It comes from a cryptographically
+// secure source as guessing it is most of what spoofing a response takes.
+func newID() uint16 {
+	b := make([]byte, 2)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("reading random id: %s", err))
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
 func WithID(id uint16) func(*Message) {
 	return func(r *Message) {
 		r.Header.ID = id
 	}
 }
+func WithResponse() func(*Message) {
+	return func(r *Message) {
+		r.Header.SetQR(true)
+	}
+}
+
+func WithOpcode(o Opcode) func(*Message) {
+	return func(r *Message) {
+		r.Header.SetOpcode(o)
+	}
+}
+
+func WithAuthoritative() func(*Message) {
+	return func(r *Message) {
+		r.Header.SetAA(true)
+	}
+}
+
+func WithTruncated() func(*Message) {
+	return func(r *Message) {
+		r.Header.SetTC(true)
+	}
+}
+
 func WithRecursionDesired() func(*Message) {
 	return func(r *Message) {
-		mask := uint16(0x0100)
-		r.Header.Flags |= mask
+		r.Header.SetRD(true)
+	}
+}
+
+func WithRecursionAvailable() func(*Message) {
+	return func(r *Message) {
+		r.Header.SetRA(true)
+	}
+}
+
+func WithAuthenticData() func(*Message) {
+	return func(r *Message) {
+		r.Header.SetAD(true)
+	}
+}
+
+func WithCheckingDisabled() func(*Message) {
+	return func(r *Message) {
+		r.Header.SetCD(true)
+	}
+}
+
+func WithRCode(rc RCode) func(*Message) {
+	return func(r *Message) {
+		r.SetRCode(rc)
 	}
 }
 
 func WithQuestion(name string, qType uint16, qClass uint16) func(*Message) {
 	return func(r *Message) {
 		q := Question{
-			QName:  newDomainName(name),
+			QName:  NewDomainName(name),
 			QType:  qType,
 			QClass: qClass,
 		}
@@ -206,180 +256,67 @@ func NewMessage(opts ...MessageOptsFunc) Message {
 		Answers:    []ResourceRecord{},
 		Authority:  []ResourceRecord{},
 		Additional: []ResourceRecord{},
-		msg:        []byte{},
 	}
 
 	for _, f := range opts {
 		f(&msg)
 	}
 
-	msg.msg = msg.encode()
 	return msg
 }
 
-func (m Message) Bytes() []byte {
-	return m.msg
-}
-
-func (m Message) encode() []byte {
-	var b bytes.Buffer
-	w := bufio.NewWriter(&b)
-	m.Header.WriteTo(w)
-	for _, q := range m.Questions {
-		q.WriteTo(w)
-	}
-	for _, a := range m.Answers {
-		a.WriteTo(w)
-	}
-	for _, a := range m.Authority {
-		a.WriteTo(w)
+// Bytes returns the wire form m was parsed from, and encodes m when it
+// wasn't parsed. Changes made to a parsed message only show in Pack.
+func (m Message) Bytes() ([]byte, error) {
+	if m.msg == nil {
+		return m.Pack()
 	}
-	for _, a := range m.Additional {
-		a.WriteTo(w)
-	}
-	w.Flush()
-	return b.Bytes()
+	return m.msg, nil
 }
 
-func (m Message) labelAtOffset(targetOffset int) (DomainName, bool) {
-	curr := m.Header.offset()
+// Pack encodes m with compressed names, taking the section counts of the
+// header from the sections themselves. It fails when a label, name or
+// character string is too long for the wire format.
+func (m Message) Pack() ([]byte, error) {
+	h := m.Header
+	h.QDCount = uint16(len(m.Questions))
+	h.ANCount = uint16(len(m.Answers))
+	h.NSCount = uint16(len(m.Authority))
+	h.ARCount = uint16(len(m.Additional))
+
+	e := newEncoder(true)
+	e.header(h)
 	for _, q := range m.Questions {
-		subCurr := 0
-		for i, l := range q.QName.labels {
-			if curr+subCurr == targetOffset {
-				lab := q.QName.labels[i:]
-				return DomainName{
-					labels: lab,
-				}, true
-			}
-			subCurr += int(l.len())
-		}
-		curr += q.offset()
+		e.question(q)
 	}
 	for _, a := range m.Answers {
-		subCurr := 0
-		for i, l := range a.Name.labels {
-			if curr+subCurr == targetOffset {
-				return DomainName{
-					labels: a.Name.labels[i:],
-				}, true
-			}
-			subCurr += int(l.len())
-		}
-
-		// no need to check rdata if its an ip
-		if a.Type == RecordTypeA {
-			curr += a.offset()
-			break
-		}
-		subCurr = a.offsetWithoutRData()
-		for i, l := range a.RData.labels {
-			if curr+subCurr == targetOffset {
-				return DomainName{
-					labels: a.RData.labels[i:],
-				}, true
-			}
-			subCurr += int(l.len())
-		}
-		curr += a.offset()
+		e.record(a)
 	}
 	for _, a := range m.Authority {
-		if curr == targetOffset {
-			return a.Name, true
-		}
-		if curr+a.offsetWithoutRData() == targetOffset {
-			return a.RData, true
-		}
-		subCurr := a.offsetWithoutRData()
-		for i, l := range a.RData.labels {
-			if l.isOffset() {
-				break
-			}
-			subCurr += int(l.len())
-			if curr+subCurr == targetOffset {
-				return DomainName{
-					labels: a.RData.labels[i+1:],
-				}, true
-			}
-		}
-		curr += a.offset()
+		e.record(a)
 	}
 	for _, a := range m.Additional {
-		if curr == targetOffset {
-			return a.Name, true
-		}
-		curr += a.offset()
+		e.record(a)
 	}
-
-	return DomainName{}, false
-}
-
-func (m Message) fullNameOfRecord(a ResourceRecord) string {
-	var sb strings.Builder
-	cur := a.Name
-outer:
-	for {
-		for _, l := range cur.labels {
-			if l.isZero() {
-				break outer
-			}
-			if l.isOffset() {
-				next, _ := m.labelAtOffset(l.offset())
-				cur = next
-				break
-			}
-			sb.WriteString(l.str)
-			sb.WriteByte('.')
-		}
-	}
-	result := sb.String()
-	return result[:len(result)-1]
-}
-
-func (m Message) fullRDataOfRecord(a ResourceRecord) string {
-	var sb strings.Builder
-	if a.Type == RecordTypeA {
-		ipBuf := []byte(a.RData.labels[0].str)
-		return fmt.Sprintf("%d.%d.%d.%d",
-			ipBuf[0],
-			ipBuf[1],
-			ipBuf[2],
-			ipBuf[3],
-		)
-	} else {
-		cur := a.RData
-	outer:
-		for {
-			for _, l := range cur.labels {
-				if l.isZero() {
-					break outer
-				}
-				if l.isOffset() {
-					next, _ := m.labelAtOffset(l.offset())
-					cur = next
-					break
-				}
-				sb.WriteString(l.str)
-				sb.WriteByte('.')
-			}
-		}
-		result := sb.String()
-		return result[:len(result)-1]
+	if e.err != nil {
+		return nil, fmt.Errorf("encoding message: %w", e.err)
 	}
+	return e.buf, nil
 }
 
+// RecordsOfDomainName returns the records of the answer and additional
+// sections owned by dns.
 func (m Message) RecordsOfDomainName(dns string) []ResourceRecord {
 	results := []ResourceRecord{}
+	dns = strings.TrimSuffix(dns, ".")
 
 	for _, a := range m.Answers {
-		fullName := m.fullNameOfRecord(a)
-		if fullName == dns {
+		if strings.EqualFold(a.Name.String(), dns) {
 			results = append(results, a)
 		}
 	}
 	for _, a := range m.Additional {
-		fullName := m.fullNameOfRecord(a)
-		if fullName == dns {
+		if strings.EqualFold(a.Name.String(), dns) {
 			results = append(results, a)
 		}
 	}
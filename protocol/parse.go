@@ -1,10 +1,10 @@
 package protocol
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
-	"strings"
 )
 
 func encodeString(s string) []byte {
@@ -14,42 +14,6 @@ func encodeString(s string) []byte {
 	return dst
 }
 
-func encodeHostName(hostName string) []byte {
-	dst := make([]byte, 0)
-	words := strings.Split(hostName, ".")
-
-	for _, word := range words {
-		dst = append(dst, byte(len(word)))
-		dst = append(dst, []byte(word)...)
-	}
-	dst = append(dst, 0)
-
-	return dst
-}
-
-func decodeHostName(b []byte) (string, error) {
-	var sb strings.Builder
-	i := 0
-	for {
-		lenLabel := int(b[i])
-		for j := i + 1; j <= i+lenLabel; j++ {
-			if j >= len(b) {
-				return "", fmt.Errorf("given label length is larger than hostname, want to index: %d, hostname length: %d", j, len(b))
-			}
-			sb.WriteByte(b[j])
-		}
-		i += lenLabel + 1
-		if i >= len(b) {
-			return "", fmt.Errorf("index larger than buffer length, index: %d, buffer length: %d", i, len(b))
-		}
-		if b[i] == 0 {
-			break
-		}
-		sb.WriteByte('.')
-	}
-	return sb.String(), nil
-}
-
 func parseHeader(r io.Reader) (Header, error) {
 	// id
 	idBuf, err := read(r, 2)
@@ -84,7 +48,7 @@ func parseHeader(r io.Reader) (Header, error) {
 	}, nil
 }
 
-func parseQuestion(r io.Reader) (Question, error) {
+func parseQuestion(r wireReader) (Question, error) {
 	// qname
 	qName, err := parseDomainName(r)
 	if err != nil {
@@ -105,7 +69,7 @@ func parseQuestion(r io.Reader) (Question, error) {
 	}, nil
 }
 
-func parseQuestions(r io.Reader, n int) ([]Question, error) {
+func parseQuestions(r wireReader, n int) ([]Question, error) {
 	questions := make([]Question, 0, n)
 	for range n {
 		q, err := parseQuestion(r)
@@ -118,7 +82,7 @@ func parseQuestions(r io.Reader, n int) ([]Question, error) {
 	return questions, nil
 }
 
-func parseRecord(r io.Reader) (ResourceRecord, error) {
+func parseRecord(r wireReader) (ResourceRecord, error) {
 	// name
 	domainName, err := parseDomainName(r)
 	if err != nil {
@@ -132,15 +96,12 @@ func parseRecord(r io.Reader) (ResourceRecord, error) {
 	}
 	kind := binary.BigEndian.Uint16(kindBuf)
 
-	// class
+	// class, which for OPT records holds the requestor's UDP payload size
 	classBuf, err := read(r, 2)
 	if err != nil {
 		return ResourceRecord{}, err
 	}
-	class := binary.BigEndian.Uint16(classBuf) % 2
-	if class != RecordClassIN {
-		return ResourceRecord{}, fmt.Errorf("record class should be IN (%d) but got %d", RecordClassIN, class)
-	}
+	class := binary.BigEndian.Uint16(classBuf)
 
 	// ttl
 	ttlBuf, err := read(r, 4)
@@ -156,54 +117,33 @@ func parseRecord(r io.Reader) (ResourceRecord, error) {
 	}
 	rdLength := binary.BigEndian.Uint16(rdLengthBuf)
 
-	// rdata
-	rdata := DomainName{}
-	switch kind {
-	case RecordTypeA:
-		rdataBuf, err := read(r, int(rdLength)) // rdLength == 4
-		if err != nil {
-			return ResourceRecord{}, fmt.Errorf("parsing rdata: %w", err)
-		}
-		rdata = newIPAddress(string(rdataBuf))
-
-	case RecordTypeNS:
-		rdata, err = parseDomainName(r)
-		if err != nil {
-			return ResourceRecord{}, fmt.Errorf("error reading name: %w", err)
-		}
-
-	case RecordTypeCNAME:
-		rdata, err = parseDomainName(r)
-		if err != nil {
-			return ResourceRecord{}, fmt.Errorf("error reading name: %w", err)
-		}
-
-	case RecordTypeAAAA:
-		rdataBuf, err := read(r, int(rdLength)) // rdLength == 16
-		if err != nil {
-			return ResourceRecord{}, fmt.Errorf("parsing rdata: %w", err)
-		}
-		rdata = newDomainName(string(rdataBuf))
-
-	default:
-		return ResourceRecord{}, fmt.Errorf(
-			"unsupported record (type,class) combination, type: %d, class: %d",
-			kind,
-			class,
-		)
+	// rdata, read from a reader that ends with it so that nothing past it
+	// is taken for part of it, pointers only going backwards
+	start := r.offset()
+	end := start + int(rdLength)
+	if end > len(r.msg) {
+		return ResourceRecord{}, fmt.Errorf("rdata of %d bytes overruns the %d bytes left", rdLength, r.Len())
+	}
+	rdataReader := newWireReader(r.msg[:end]).at(start)
+	rdata, err := unpackRData(kind, rdataReader, int(rdLength))
+	if err != nil {
+		return ResourceRecord{}, fmt.Errorf("parsing %s rdata: %w", TypeString(kind), err)
 	}
+	if rdataReader.Len() != 0 {
+		return ResourceRecord{}, fmt.Errorf("%s rdata is %d bytes long but only %d were used", TypeString(kind), rdLength, int(rdLength)-rdataReader.Len())
+	}
+	r.Seek(int64(end), io.SeekStart)
 
 	return ResourceRecord{
-		Name:     domainName,
-		Type:     kind,
-		Class:    class,
-		TTL:      ttl,
-		RDLength: rdLength,
-		RData:    rdata,
+		Name:  domainName,
+		Type:  kind,
+		Class: class,
+		TTL:   ttl,
+		RData: rdata,
 	}, nil
 }
 
-func parseRecords(r io.Reader, n int) ([]ResourceRecord, error) {
+func parseRecords(r wireReader, n int) ([]ResourceRecord, error) {
 	answers := make([]ResourceRecord, 0, n)
 	for range n {
 		q, err := parseRecord(r)
@@ -216,11 +156,23 @@ func parseRecords(r io.Reader, n int) ([]ResourceRecord, error) {
 	return answers, nil
 }
 
-func Parse(r io.Reader) (Message, error) {
+// Parse reads a whole message from r. Names are expanded as they are read,
+// so the records of the message don't refer back to it.
+func Parse(in io.Reader) (Message, error) {
+	msg, err := io.ReadAll(in)
+	if err != nil {
+		return Message{}, err
+	}
+	r := newWireReader(msg)
+
 	header, err := parseHeader(r)
 	if err != nil {
 		return Message{}, fmt.Errorf("reading header: %w", err)
 	}
+	records := int(header.ANCount) + int(header.NSCount) + int(header.ARCount)
+	if need := int(header.QDCount)*minQuestionLength + records*minRecordLength; need > r.Len() {
+		return Message{}, fmt.Errorf("header counts %d questions and %d records, which take at least %d bytes but %d are left", header.QDCount, records, need, r.Len())
+	}
 	questions, err := parseQuestions(r, int(header.QDCount))
 	if err != nil {
 		return Message{}, fmt.Errorf("reading questions: %w", err)
@@ -235,7 +187,7 @@ func Parse(r io.Reader) (Message, error) {
 	}
 	adds, err := parseRecords(r, int(header.ARCount))
 	if err != nil {
-		return Message{}, fmt.Errorf("reading authorities: %w", err)
+		return Message{}, fmt.Errorf("reading additional records: %w", err)
 	}
 	m := Message{
 		Header:     header,
@@ -243,20 +195,45 @@ func Parse(r io.Reader) (Message, error) {
 		Answers:    answers,
 		Authority:  auths,
 		Additional: adds,
+		msg:        msg,
 	}
-	m.msg = m.encode()
 
 	return m, nil
 }
 
+// wireReader reads a message front to back while keeping all of it at hand
+// for compression pointers to be followed.
+type wireReader struct {
+	*bytes.Reader
+	msg []byte
+}
+
+func newWireReader(msg []byte) wireReader {
+	return wireReader{
+		Reader: bytes.NewReader(msg),
+		msg:    msg,
+	}
+}
+
+// at returns a reader over the same message positioned at offset.
+func (r wireReader) at(offset int) wireReader {
+	other := newWireReader(r.msg)
+	other.Seek(int64(offset), io.SeekStart)
+	return other
+}
+
+// offset returns the position of r in the message.
+func (r wireReader) offset() int {
+	return len(r.msg) - r.Len()
+}
+
+// read reads exactly size bytes from r, failing with io.ErrUnexpectedEOF
+// when r ends before.
 func read(r io.Reader, size int) ([]byte, error) {
 	b := make([]byte, size)
-	n, err := r.Read(b)
+	n, err := io.ReadFull(r, b)
 	if err != nil {
-		return b, err
-	}
-	if size != n {
-		return b, fmt.Errorf("not enough bytes read")
+		return nil, fmt.Errorf("reading %d bytes but only %d are left: %w", size, n, io.ErrUnexpectedEOF)
 	}
 
 	return b, nil
@@ -0,0 +1,432 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+var classNames = map[uint16]string{
+	RecordClassIN: "IN",
+	3:             "CH",
+	4:             "HS",
+	255:           "ANY",
+}
+
+// ClassString returns the mnemonic of class c, or CLASSn for classes
+// without one as RFC 3597 has it.
+func ClassString(c uint16) string {
+	if name, ok := classNames[c]; ok {
+		return name
+	}
+	return "CLASS" + strconv.Itoa(int(c))
+}
+
+// String returns the question in presentation format, name class type.
+func (q Question) String() string {
+	return fmt.Sprintf("%s\t%s\t%s", q.QName.fqdn(), ClassString(q.QClass), TypeString(q.QType))
+}
+
+// String returns the record in presentation format, name ttl class type
+// rdata.
+func (a ResourceRecord) String() string {
+	return fmt.Sprintf("%s\t%d\t%s\t%s\t%s", a.Name.fqdn(), a.TTL, ClassString(a.Class), TypeString(a.Type), a.RData)
+}
+
+// String returns the message in presentation format the way dig prints
+// it, the header and EDNS information as comments followed by the
+// sections that aren't empty.
+func (m Message) String() string {
+	var sb strings.Builder
+
+	h := m.Header
+	fmt.Fprintf(&sb, ";; ->>HEADER<<- opcode: %s, status: %s, id: %d\n", h.Opcode(), m.RCode(), h.ID)
+	flags := []string{}
+	for _, f := range []struct {
+		name string
+		on   bool
+	}{
+		{"qr", h.QR()}, {"aa", h.AA()}, {"tc", h.TC()}, {"rd", h.RD()},
+		{"ra", h.RA()}, {"ad", h.AD()}, {"cd", h.CD()},
+	} {
+		if f.on {
+			flags = append(flags, f.name)
+		}
+	}
+	fmt.Fprintf(&sb, ";; flags: %s; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d\n",
+		strings.Join(flags, " "), len(m.Questions), len(m.Answers), len(m.Authority), len(m.Additional))
+
+	if e, ok := m.EDNS(); ok {
+		do := ""
+		if e.DO {
+			do = " do"
+		}
+		sb.WriteString("\n;; OPT PSEUDOSECTION:\n")
+		fmt.Fprintf(&sb, "; EDNS: version: %d, flags:%s; udp: %d\n", e.Version, do, e.UDPSize)
+		for _, opt := range e.Options {
+			fmt.Fprintf(&sb, "; %s\n", opt)
+		}
+	}
+
+	if len(m.Questions) != 0 {
+		sb.WriteString("\n;; QUESTION SECTION:\n")
+		for _, q := range m.Questions {
+			fmt.Fprintf(&sb, ";%s\n", q)
+		}
+	}
+
+	for _, section := range []struct {
+		name    string
+		records []ResourceRecord
+	}{
+		{"ANSWER", m.Answers},
+		{"AUTHORITY", m.Authority},
+		{"ADDITIONAL", m.Additional},
+	} {
+		records := []ResourceRecord{}
+		for _, rr := range section.records {
+			if rr.Type != RecordTypeOPT {
+				records = append(records, rr)
+			}
+		}
+		if len(records) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n;; %s SECTION:\n", section.name)
+		for _, rr := range records {
+			fmt.Fprintf(&sb, "%s\n", rr)
+		}
+	}
+
+	return sb.String()
+}
+
+// parseRData reads rdata of record type typ from its presentation format
+// s, as String writes it, failing for rdata that can't be encoded.
+func parseRData(typ uint16, s string) (RData, error) {
+	rdata, err := parseRDataFields(typ, s)
+	if err != nil {
+		return nil, err
+	}
+	e := newEncoder(false)
+	rdata.pack(e)
+	if e.err != nil {
+		return nil, e.err
+	}
+	return rdata, nil
+}
+
+func parseRDataFields(typ uint16, s string) (RData, error) {
+	fields, err := presentationFields(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 0 && fields[0] == `\#` {
+		return parseGenericRData(typ, fields[1:])
+	}
+	number := func(i int, bits int) (uint64, error) {
+		return strconv.ParseUint(fields[i], 10, bits)
+	}
+
+	want := map[uint16]int{
+		RecordTypeA: 1, RecordTypeAAAA: 1, RecordTypeNS: 1, RecordTypeCNAME: 1,
+		RecordTypePTR: 1, RecordTypeMX: 2, RecordTypeSRV: 4, RecordTypeSOA: 7,
+		RecordTypeNAPTR: 6, RecordTypeURI: 3, RecordTypeCAA: 3,
+	}
+	least := map[uint16]int{RecordTypeSSHFP: 3, RecordTypeTLSA: 4}
+	if n, ok := want[typ]; ok && len(fields) != n {
+		return nil, fmt.Errorf("%s rdata has %d fields instead of %d", TypeString(typ), len(fields), n)
+	}
+	if n, ok := least[typ]; ok && len(fields) < n {
+		return nil, fmt.Errorf("%s rdata has %d fields, fewer than %d", TypeString(typ), len(fields), n)
+	}
+
+	// names are parsed ahead of the other fields, for their escapes to fail
+	// early
+	nameFields := map[uint16][]int{
+		RecordTypeNS: {0}, RecordTypeCNAME: {0}, RecordTypePTR: {0}, RecordTypeMX: {1},
+		RecordTypeSRV: {3}, RecordTypeSOA: {0, 1}, RecordTypeNAPTR: {5},
+	}
+	names := make([]DomainName, len(fields))
+	for _, i := range nameFields[typ] {
+		if names[i], err = parseName(fields[i]); err != nil {
+			return nil, err
+		}
+	}
+	name := func(i int) DomainName {
+		return names[i]
+	}
+
+	switch typ {
+	case RecordTypeA, RecordTypeAAAA:
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		if typ == RecordTypeA && addr.Is4() {
+			return A{Addr: addr}, nil
+		}
+		if typ == RecordTypeAAAA && addr.Is6() {
+			return AAAA{Addr: addr}, nil
+		}
+		return nil, fmt.Errorf("%s is not an address for %s", addr, TypeString(typ))
+	case RecordTypeNS:
+		return NS{Host: name(0)}, nil
+	case RecordTypeCNAME:
+		return CNAME{Target: name(0)}, nil
+	case RecordTypePTR:
+		return PTR{Host: name(0)}, nil
+	case RecordTypeMX:
+		preference, err := number(0, 16)
+		if err != nil {
+			return nil, err
+		}
+		return MX{Preference: uint16(preference), Exchange: name(1)}, nil
+	case RecordTypeSRV:
+		values := [3]uint64{}
+		for i := range values {
+			v, err := number(i, 16)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return SRV{Priority: uint16(values[0]), Weight: uint16(values[1]), Port: uint16(values[2]), Target: name(3)}, nil
+	case RecordTypeSOA:
+		values := [5]uint64{}
+		for i := range values {
+			v, err := number(i+2, 32)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return SOA{
+			MName:   name(0),
+			RName:   name(1),
+			Serial:  uint32(values[0]),
+			Refresh: uint32(values[1]),
+			Retry:   uint32(values[2]),
+			Expire:  uint32(values[3]),
+			Minimum: uint32(values[4]),
+		}, nil
+	case RecordTypeTXT:
+		strs, err := unquoteCharacterStrings(s)
+		if err != nil {
+			return nil, err
+		}
+		return TXT{Strings: strs}, nil
+	case RecordTypeNAPTR:
+		values := [2]uint64{}
+		for i := range values {
+			if values[i], err = number(i, 16); err != nil {
+				return nil, err
+			}
+		}
+		strs := [3]string{}
+		for i := range strs {
+			if strs[i], err = unquoteField(fields[i+2]); err != nil {
+				return nil, err
+			}
+		}
+		return NAPTR{
+			Order:       uint16(values[0]),
+			Preference:  uint16(values[1]),
+			Flags:       strs[0],
+			Services:    strs[1],
+			Regexp:      strs[2],
+			Replacement: name(5),
+		}, nil
+	case RecordTypeSSHFP, RecordTypeTLSA:
+		n := least[typ] - 1
+		values := make([]uint8, n)
+		for i := range values {
+			v, err := number(i, 8)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = uint8(v)
+		}
+		data, err := hex.DecodeString(strings.Join(fields[n:], ""))
+		if err != nil {
+			return nil, err
+		}
+		if typ == RecordTypeSSHFP {
+			return SSHFP{Algorithm: values[0], FPType: values[1], Fingerprint: data}, nil
+		}
+		return TLSA{Usage: values[0], Selector: values[1], MatchingType: values[2], CertData: data}, nil
+	case RecordTypeSVCB:
+		return parseSVCB(fields)
+	case RecordTypeHTTPS:
+		svcb, err := parseSVCB(fields)
+		if err != nil {
+			return nil, err
+		}
+		return HTTPS{SVCB: svcb}, nil
+	case RecordTypeURI:
+		values := [2]uint64{}
+		for i := range values {
+			if values[i], err = number(i, 16); err != nil {
+				return nil, err
+			}
+		}
+		target, err := unquoteField(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		return URI{Priority: uint16(values[0]), Weight: uint16(values[1]), Target: target}, nil
+	case RecordTypeCAA:
+		flags, err := number(0, 8)
+		if err != nil {
+			return nil, err
+		}
+		value, err := unquoteField(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		// tags are letters and digits only, RFC 8659
+		if fields[1] == "" || strings.IndexFunc(fields[1], func(c rune) bool {
+			return !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9')
+		}) >= 0 {
+			return nil, fmt.Errorf("caa tag %s is not made of letters and digits", fields[1])
+		}
+		return CAA{Flags: uint8(flags), Tag: fields[1], Value: value}, nil
+	default:
+		return nil, fmt.Errorf("%s rdata is not in the generic form \\# length hex", TypeString(typ))
+	}
+}
+
+// parseGenericRData parses the length and hex fields following \# in the
+// generic form of RFC 3597, which any type may be given in.
+func parseGenericRData(typ uint16, fields []string) (RData, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("generic rdata is missing its length")
+	}
+	length, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid generic rdata length: %w", err)
+	}
+	data, err := hex.DecodeString(strings.Join(fields[1:], ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid generic rdata: %w", err)
+	}
+	if len(data) != int(length) {
+		return nil, fmt.Errorf("generic rdata is %d bytes long instead of %d", len(data), length)
+	}
+
+	return unpackWholeRData(typ, data)
+}
+
+// unpackWholeRData reads rdata of record type typ that was given apart from
+// a message, which has to be used up by it.
+func unpackWholeRData(typ uint16, data []byte) (RData, error) {
+	r := newWireReader(data)
+	rdata, err := unpackRData(typ, r, len(data))
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%s rdata is %d bytes long but only %d were used", TypeString(typ), len(data), len(data)-r.Len())
+	}
+	return rdata, nil
+}
+
+// presentationFields splits s into its whitespace separated fields, which
+// keep their quotes and escapes, quoted strings being able to hold spaces.
+func presentationFields(s string) ([]string, error) {
+	fields := []string{}
+	start, quoted := -1, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !quoted && (c == ' ' || c == '\t' || c == '\n') {
+			if start >= 0 {
+				fields = append(fields, s[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		switch c {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("quoted string in %q is not terminated", s)
+	}
+	if start >= 0 {
+		fields = append(fields, s[start:])
+	}
+	return fields, nil
+}
+
+// unquoteField returns the <character-string> of a field, which may be
+// quoted or not.
+func unquoteField(field string) (string, error) {
+	if !strings.HasPrefix(field, `"`) {
+		field = `"` + field + `"`
+	}
+	strs, err := unquoteCharacterStrings(field)
+	if err != nil {
+		return "", err
+	}
+	if len(strs) != 1 {
+		return "", fmt.Errorf("field %s holds %d character strings instead of 1", field, len(strs))
+	}
+	return strs[0], nil
+}
+
+// unquoteCharacterStrings reads the quoted <character-string>s of s, the
+// reverse of quoteCharacterString.
+func unquoteCharacterStrings(s string) ([]string, error) {
+	strs := []string{}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if s[0] != '"' {
+			return nil, fmt.Errorf("character string %q is not quoted", s)
+		}
+
+		var sb strings.Builder
+		i, closed := 1, false
+		for i < len(s) && !closed {
+			c := s[i]
+			switch {
+			case c == '"':
+				closed = true
+			case c == '\\' && i+3 < len(s) && isDigits(s[i+1:i+4]):
+				n, _ := strconv.Atoi(s[i+1 : i+4])
+				if n > 255 {
+					return nil, fmt.Errorf("escape \\%s is out of range", s[i+1:i+4])
+				}
+				sb.WriteByte(byte(n))
+				i += 3
+			case c == '\\' && i+1 < len(s):
+				i++
+				sb.WriteByte(s[i])
+			default:
+				sb.WriteByte(c)
+			}
+			i++
+		}
+		if !closed {
+			return nil, fmt.Errorf("character string %q is not terminated", s)
+		}
+		strs = append(strs, sb.String())
+		s = s[i:]
+	}
+	return strs, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
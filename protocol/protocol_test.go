@@ -1,11 +1,12 @@
 package protocol
 
 import (
-	"bufio"
+	"bytes"
+	"context"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"log"
-	"net"
+	"net/netip"
 	"reflect"
 	"slices"
 	"strings"
@@ -30,8 +31,10 @@ func Test_step1(t *testing.T) {
 		WithQuestion("dns.google.com", 1, 1),
 	)
 
-	got := req.Bytes()
-
+	got, err := req.Bytes()
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
 	if !reflect.DeepEqual(want, got) {
 		t.Fatalf("expected msg encoding to look like\n%v\nbut got\n%v\n", want, got)
 	}
@@ -41,21 +44,10 @@ func Test_step2(t *testing.T) {
 	var (
 		want = 22
 
-		ip   = net.IPv4(8, 8, 8, 8)
-		port = 53
+		ip   = netip.AddrFrom4([4]byte{8, 8, 8, 8})
+		port = uint16(53)
 	)
-	addr := &net.UDPAddr{
-		IP:   ip,
-		Port: port,
-		Zone: "",
-	}
-
-	conn, err := net.DialUDP("udp", nil, addr)
-	defer conn.Close()
-	if err != nil {
-		log.Fatalf("error dialing google")
-	}
-	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	addr := netip.AddrPortFrom(ip, port)
 
 	req := NewMessage(
 		WithID(22),
@@ -63,7 +55,7 @@ func Test_step2(t *testing.T) {
 		WithQuestion("dns.google.com", 1, 1),
 	)
 
-	resp, err := writeReqReadResp(rw, req)
+	resp, err := testTransport().Exchange(context.Background(), addr, req)
 	if err != nil {
 		t.Fatalf("error while sending/reading request %s", err)
 	}
@@ -83,21 +75,10 @@ func Test_step3(t *testing.T) {
 		}
 
 		target = "dns.google.com"
-		ip     = net.IPv4(8, 8, 8, 8)
-		port   = 53
+		ip     = netip.AddrFrom4([4]byte{8, 8, 8, 8})
+		port   = uint16(53)
 	)
-	addr := &net.UDPAddr{
-		IP:   ip,
-		Port: port,
-		Zone: "",
-	}
-
-	conn, err := net.DialUDP("udp", nil, addr)
-	defer conn.Close()
-	if err != nil {
-		log.Fatalf("error dialing google")
-	}
-	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	addr := netip.AddrPortFrom(ip, port)
 
 	req := NewMessage(
 		WithID(22),
@@ -105,7 +86,7 @@ func Test_step3(t *testing.T) {
 		WithQuestion(target, 1, 1),
 	)
 
-	resp, err := writeReqReadResp(rw, req)
+	resp, err := testTransport().Exchange(context.Background(), addr, req)
 	if err != nil {
 		t.Fatalf("error while sending/reading request %s", err)
 	}
@@ -113,13 +94,7 @@ func Test_step3(t *testing.T) {
 	records := resp.RecordsOfDomainName(target)
 	got := []string{}
 	for _, rec := range records {
-		ipBuf := resp.formattedRDataOf(rec)
-		got = append(got, fmt.Sprintf("%d.%d.%d.%d",
-			ipBuf[0],
-			ipBuf[1],
-			ipBuf[2],
-			ipBuf[3],
-		))
+		got = append(got, rec.RData.String())
 	}
 	slices.Sort(got)
 
@@ -136,54 +111,38 @@ func Test_step4(t *testing.T) {
 		}
 
 		target = "dns.google.com"
-		ip     = net.IPv4(198, 41, 0, 4)
-		port   = 53
+		ip     = netip.AddrFrom4([4]byte{198, 41, 0, 4})
+		port   = uint16(53)
 	)
 	req := NewMessage(
 		WithID(22),
 		WithQuestion(target, 1, 1),
 	)
 
+	transport := testTransport()
 	for {
-		addr := &net.UDPAddr{
-			IP:   ip,
-			Port: port,
-			Zone: "",
-		}
-
-		conn, err := net.DialUDP("udp", nil, addr)
-		if err != nil {
-			log.Fatalf("error dialing %s", err)
-		}
-		defer conn.Close()
-		rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+		addr := netip.AddrPortFrom(ip, port)
 
-		resp, err := writeReqReadResp(rw, req)
+		resp, err := transport.Exchange(context.Background(), addr, req)
 		if err != nil {
-			log.Fatalf("error while sending/reading request %s", err)
+			t.Fatalf("error while sending/reading request %s", err)
 		}
 
 		results := resp.RecordsOfDomainName("dns.google.com")
 		if len(results) == 0 {
 			nextRoot := resp.Authority[0]
-			fullName := resp.fullRDataOfRecord(nextRoot)
+			fullName := nextRoot.RData.(NS).Host.String()
 			adds := resp.RecordsOfDomainName(fullName)
 			for _, a := range adds {
 				if a.Type == RecordTypeA {
-					ip = net.IP(resp.formattedRDataOf(a))
+					ip, _ = a.Addr()
 					break
 				}
 			}
 		} else {
 			got := []string{}
 			for _, rec := range results {
-				ipBuf := resp.formattedRDataOf(rec)
-				got = append(got, fmt.Sprintf("%d.%d.%d.%d",
-					ipBuf[0],
-					ipBuf[1],
-					ipBuf[2],
-					ipBuf[3],
-				))
+				got = append(got, rec.RData.String())
 			}
 			slices.Sort(got)
 
@@ -195,3 +154,603 @@ func Test_step4(t *testing.T) {
 
 	}
 }
+
+func Test_parseRData(t *testing.T) {
+	var (
+		want = []string{
+			"www.example.com. CNAME example.com.",
+			"example.com. A 93.184.216.34",
+			"example.com. MX 10 mail.example.com.",
+			`example.com. TXT "v=spf1 -all" "a\"b\\c"`,
+			"_sip._tcp.example.com. SRV 10 60 5060 sip.example.com.",
+			"example.com. SOA ns.example.com. admin.example.com. 2024010101 7200 3600 1209600 300",
+			"ns.example.com. AAAA 2001:db8::1",
+		}
+		types = map[uint16]string{
+			RecordTypeA:     "A",
+			RecordTypeCNAME: "CNAME",
+			RecordTypeSOA:   "SOA",
+			RecordTypeMX:    "MX",
+			RecordTypeTXT:   "TXT",
+			RecordTypeAAAA:  "AAAA",
+			RecordTypeSRV:   "SRV",
+		}
+	)
+	packet, _ := hex.DecodeString("123481800001000500010001076578616d706c6503636f6d0000ff000103777777c00c000500010000012c0002c00cc00c000100010000012c00045db8d822c00c000f00010000012c0009000a046d61696cc00cc00c001000010000012c00120b763d73706631202d616c6c056122625c63045f736970045f746370c00c002100010000012c000c000a003c13c403736970c00cc00c0006000100000e100021026e73c00c0561646d696ec00c78a3f17500001c2000000e10001275000000012c026e73c00c001c00010000012c001020010db8000000000000000000000001")
+
+	msg, err := Parse(bytes.NewReader(packet))
+	if err != nil {
+		t.Fatalf("error parsing message %s", err)
+	}
+
+	got := []string{}
+	for _, section := range [][]ResourceRecord{msg.Answers, msg.Authority, msg.Additional} {
+		for _, rr := range section {
+			got = append(got, fmt.Sprintf("%s %s %s", rr.Name.fqdn(), types[rr.Type], rr.RData))
+		}
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected records to be\n%v\nbut got\n%v\n", want, got)
+	}
+}
+
+func Test_parseCompressionPointers(t *testing.T) {
+	header := "123481800001000000000000"
+	tests := map[string]string{
+		"pointer to itself":   header + "c00c00010001",
+		"forward pointer":     header + "c00e00010001" + "03636f6d00",
+		"loop between labels": header + "03777777c01200010001" + "03636f6dc00c",
+	}
+
+	for name, packet := range tests {
+		b, _ := hex.DecodeString(packet)
+		if _, err := Parse(bytes.NewReader(b)); err == nil {
+			t.Fatalf("%s: expected parsing to fail", name)
+		}
+	}
+
+	b, _ := hex.DecodeString("123481800001000100000000" + "03777777076578616d706c6503636f6d0000010001" + "c00c000100010000012c00045db8d822")
+	msg, err := Parse(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("error parsing message %s", err)
+	}
+	if got := msg.Answers[0].Name.String(); got != "www.example.com" {
+		t.Fatalf("expected pointer to expand to www.example.com but got %s", got)
+	}
+	if raw, _ := msg.Bytes(); !reflect.DeepEqual(b, raw) {
+		t.Fatalf("expected parsed message to keep its raw bytes")
+	}
+}
+
+func Test_packCompression(t *testing.T) {
+	packet, _ := hex.DecodeString("123481800001000500010001076578616d706c6503636f6d0000ff000103777777c00c000500010000012c0002c00cc00c000100010000012c00045db8d822c00c000f00010000012c0009000a046d61696cc00cc00c001000010000012c00120b763d73706631202d616c6c056122625c63045f736970045f746370c00c002100010000012c000c000a003c13c403736970c00cc00c0006000100000e100021026e73c00c0561646d696ec00c78a3f17500001c2000000e10001275000000012c026e73c00c001c00010000012c001020010db8000000000000000000000001")
+	msg, err := Parse(bytes.NewReader(packet))
+	if err != nil {
+		t.Fatalf("error parsing message %s", err)
+	}
+
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	srvTarget, _ := hex.DecodeString("03736970076578616d706c6503636f6d00")
+	if !bytes.Contains(packed, srvTarget) {
+		t.Fatalf("expected srv target to be written uncompressed")
+	}
+	// the additional ns.example.com now points at the soa mname as a whole
+	if want := len(packet) + len(srvTarget) - 6 - 3; len(packed) != want {
+		t.Fatalf("expected packed message to be %d bytes long but got %d", want, len(packed))
+	}
+
+	reparsed, err := Parse(bytes.NewReader(packed))
+	if err != nil {
+		t.Fatalf("error parsing packed message %s", err)
+	}
+	reparsed.msg, msg.msg = nil, nil
+	if !reflect.DeepEqual(msg, reparsed) {
+		t.Fatalf("expected packed message to parse to\n%v\nbut got\n%v\n", msg, reparsed)
+	}
+}
+
+func Test_headerFlags(t *testing.T) {
+	msg := NewMessage(
+		WithResponse(),
+		WithOpcode(OpcodeNotify),
+		WithAuthoritative(),
+		WithRecursionDesired(),
+		WithCheckingDisabled(),
+		WithRCode(RCodeRefused),
+	)
+
+	if want, got := uint16(0xa515), msg.Header.Flags; want != got {
+		t.Fatalf("expected flags to be %#04x but got %#04x", want, got)
+	}
+
+	h := msg.Header
+	got := []bool{h.QR(), h.AA(), h.TC(), h.RD(), h.RA(), h.AD(), h.CD()}
+	want := []bool{true, true, false, true, false, false, true}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected qr aa tc rd ra ad cd to be\n%v\nbut got\n%v\n", want, got)
+	}
+	if h.Opcode() != OpcodeNotify || h.Opcode().String() != "NOTIFY" {
+		t.Fatalf("expected opcode NOTIFY but got %s", h.Opcode())
+	}
+	if h.RCode() != RCodeRefused || h.RCode().String() != "REFUSED" {
+		t.Fatalf("expected rcode REFUSED but got %s", h.RCode())
+	}
+
+	h.SetRD(false)
+	h.SetRCode(RCodeNoError)
+	if want, got := uint16(0xa410), h.Flags; want != got {
+		t.Fatalf("expected flags to be %#04x after clearing rd and rcode but got %#04x", want, got)
+	}
+}
+
+func Test_edns(t *testing.T) {
+	msg := NewMessage(
+		WithResponse(),
+		WithQuestion("example.com", RecordTypeA, RecordClassIN),
+		WithEDNS(4096, true),
+		WithEDNSOptions(
+			NSID{Data: []byte("ns1")},
+			Cookie{Client: []byte{1, 2, 3, 4, 5, 6, 7, 8}, Server: []byte{9, 10, 11, 12, 13, 14, 15, 16}},
+			ClientSubnet{SourcePrefix: 24, Address: netip.MustParseAddr("192.0.2.0")},
+			ExtendedError{InfoCode: 18, ExtraText: "prohibited"},
+			Padding{Length: 5},
+		),
+		WithRCode(RCodeBadCookie),
+	)
+
+	b, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	parsed, err := Parse(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+
+	if want, got := RCodeBadCookie, parsed.RCode(); want != got {
+		t.Fatalf("expected rcode %s but got %s", want, got)
+	}
+	if want, got := RCode(RCodeBadCookie&0xf), parsed.Header.RCode(); want != got {
+		t.Fatalf("expected header rcode %s but got %s", want, got)
+	}
+
+	e, ok := parsed.EDNS()
+	if !ok {
+		t.Fatalf("expected an OPT record")
+	}
+	want := EDNS{
+		UDPSize:       4096,
+		ExtendedRCode: 1,
+		DO:            true,
+		Options: []EDNSOption{
+			NSID{Data: []byte("ns1")},
+			Cookie{Client: []byte{1, 2, 3, 4, 5, 6, 7, 8}, Server: []byte{9, 10, 11, 12, 13, 14, 15, 16}},
+			ClientSubnet{SourcePrefix: 24, Address: netip.MustParseAddr("192.0.2.0")},
+			ExtendedError{InfoCode: 18, ExtraText: "prohibited"},
+			Padding{Length: 5},
+		},
+	}
+	if !reflect.DeepEqual(want, e) {
+		t.Fatalf("expected edns to be\n%+v\nbut got\n%+v\n", want, e)
+	}
+
+	parsed.RemoveEDNS()
+	if _, ok := parsed.EDNS(); ok || parsed.RCode() != RCodeBadCookie&0xf {
+		t.Fatalf("expected no OPT record and a plain rcode after removing edns")
+	}
+}
+
+func Test_reverseName(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1":          "1.2.0.192.in-addr.arpa",
+		"::ffff:10.0.0.1":    "1.0.0.10.in-addr.arpa",
+		"2001:db8::567:89ab": "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+	}
+
+	for addr, want := range tests {
+		got, err := ReverseName(netip.MustParseAddr(addr))
+		if err != nil {
+			t.Fatalf("expected no error but got %s", err)
+		}
+		if want != got {
+			t.Fatalf("expected reverse name of %s to be %s but got %s", addr, want, got)
+		}
+	}
+	if _, err := ReverseName(netip.Addr{}); err == nil {
+		t.Fatalf("expected the zero address to have no reverse name")
+	}
+}
+
+func Test_messageString(t *testing.T) {
+	msg := NewMessage(
+		WithID(4660),
+		WithResponse(),
+		WithRecursionDesired(),
+		WithRecursionAvailable(),
+		WithQuestion("www.example.com", RecordTypeA, RecordClassIN),
+		WithEDNS(1232, true),
+		WithEDNSOptions(NSID{Data: []byte("ns1")}),
+	)
+	msg.Answers = []ResourceRecord{
+		{Name: NewDomainName("www.example.com"), Type: RecordTypeCNAME, Class: RecordClassIN, TTL: 300, RData: CNAME{Target: NewDomainName("example.com")}},
+		{Name: NewDomainName("example.com"), Type: RecordTypeA, Class: RecordClassIN, TTL: 60, RData: A{Addr: netip.MustParseAddr("192.0.2.1")}},
+	}
+
+	want := `;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 4660
+;; flags: qr rd ra; QUERY: 1, ANSWER: 2, AUTHORITY: 0, ADDITIONAL: 1
+
+;; OPT PSEUDOSECTION:
+; EDNS: version: 0, flags: do; udp: 1232
+; NSID: 6e7331 ("ns1")
+
+;; QUESTION SECTION:
+;www.example.com.	IN	A
+
+;; ANSWER SECTION:
+www.example.com.	300	IN	CNAME	example.com.
+example.com.	60	IN	A	192.0.2.1
+`
+	if got := msg.String(); want != got {
+		t.Fatalf("expected message to print as\n%s\nbut got\n%s\n", want, got)
+	}
+}
+
+func Test_nameEscapes(t *testing.T) {
+	name := DomainName{labels: []Label{
+		{length: 3, str: "a.b"}, {length: 9, str: "x y\"z;\\\x00\xff"}, {length: 7, str: "example"}, {},
+	}}
+	want := `a\.b.x\032y\034z\059\092\000\255.example.`
+	if got := name.fqdn(); want != got {
+		t.Fatalf("expected name to print as %s but got %s", want, got)
+	}
+
+	// printed names are read back by parseRData and the json decoder
+	rdata, err := parseRData(RecordTypePTR, want)
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if got := rdata.(PTR).Host; !reflect.DeepEqual(name, got) {
+		t.Fatalf("expected name to be read back as\n%#v\nbut got\n%#v\n", name, got)
+	}
+	rr := ResourceRecord{Name: name, Type: RecordTypePTR, Class: RecordClassIN, TTL: 300, RData: rdata}
+	b, err := json.Marshal(rr)
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	var decoded ResourceRecord
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if !reflect.DeepEqual(rr, decoded) {
+		t.Fatalf("expected json to decode to\n%v\nbut got\n%v\n", rr, decoded)
+	}
+
+	for in, want := range map[string]string{`example\.com.`: `example\.com.`, `Example.COM`: `Example.COM.`, `.`: `.`, ``: `.`} {
+		dn, err := parseName(in)
+		if err != nil {
+			t.Fatalf("%q: expected no error but got %s", in, err)
+		}
+		if got := dn.fqdn(); want != got {
+			t.Fatalf("expected %q to be read back as %s but got %s", in, want, got)
+		}
+	}
+	for _, s := range []string{`a..b.`, `.a.`, `a\256.`, `a\`} {
+		if _, err := parseName(s); err == nil {
+			t.Fatalf("expected name %q to fail", s)
+		}
+	}
+}
+
+func Test_json(t *testing.T) {
+	packet, _ := hex.DecodeString("123481800001000500010001076578616d706c6503636f6d0000ff000103777777c00c000500010000012c0002c00cc00c000100010000012c00045db8d822c00c000f00010000012c0009000a046d61696cc00cc00c001000010000012c00120b763d73706631202d616c6c056122625c63045f736970045f746370c00c002100010000012c000c000a003c13c403736970c00cc00c0006000100000e100021026e73c00c0561646d696ec00c78a3f17500001c2000000e10001275000000012c026e73c00c001c00010000012c001020010db8000000000000000000000001")
+	msg, err := Parse(bytes.NewReader(packet))
+	if err != nil {
+		t.Fatalf("error parsing message %s", err)
+	}
+	msg.SetEDNS(EDNS{UDPSize: 1232, Options: []EDNSOption{NSID{Data: []byte("ns1")}}})
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	var decoded Message
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	msg.msg = nil
+	msg.Header.ARCount++
+	if !reflect.DeepEqual(msg, decoded) {
+		t.Fatalf("expected json to decode to\n%v\nbut got\n%v\n", msg, decoded)
+	}
+
+	// readers may only give the rdata in presentation format
+	var rr ResourceRecord
+	in := `{"NAME": "example.com.", "TYPE": 16, "CLASS": 1, "TTL": 300, "rdataTXT": "\"v=spf1 -all\" \"a\\\"b\\\\c\\009\""}`
+	if err := json.Unmarshal([]byte(in), &rr); err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if want, got := []string{"v=spf1 -all", "a\"b\\c\t"}, rr.RData.(TXT).Strings; !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected txt strings to be\n%q\nbut got\n%q\n", want, got)
+	}
+
+	// rdata longer than its type reads is rejected like in the generic form
+	in = `{"NAME": "example.com.", "TYPE": 15, "CLASS": 1, "TTL": 300, "RDATAHEX": "000a00ff"}`
+	if err := json.Unmarshal([]byte(in), &rr); err == nil {
+		t.Fatalf("expected rdata with trailing bytes to fail")
+	}
+}
+
+func Test_parseHostile(t *testing.T) {
+	header := "123481800001000100000000"
+	question := "076578616d706c6503636f6d0000010001"
+	tests := map[string]string{
+		"truncated header":         "1234818000010000",
+		"counts beyond message":    "12348180ffffffffffffffff",
+		"truncated label":          "123481800001000000000000" + "076578616d70",
+		"label length past end":    "123481800001000000000000" + "3f61",
+		"name longer than 255":     "123481800001000000000000" + strings.Repeat("3f"+strings.Repeat("61", 63), 4) + "0000010001",
+		"rdlength past end":        header + question + "c00c000100010000012c00ff5db8d822",
+		"rdata shorter than A":     header + question + "c00c000100010000012c00035db8d8" + "00",
+		"rdata longer than A":      header + question + "c00c000100010000012c00055db8d82200",
+		"mx name past rdlength":    header + question + "c00c000f00010000012c0004000a0461" + "6161610000",
+		"soa fields past rdlength": header + question + "c00c000600010000012c0004c00cc00c" + "0000000000000000000000000000000000000000",
+	}
+
+	for name, packet := range tests {
+		b, err := hex.DecodeString(packet)
+		if err != nil {
+			t.Fatalf("%s: invalid test packet: %s", name, err)
+		}
+		if _, err := Parse(bytes.NewReader(b)); err == nil {
+			t.Fatalf("%s: expected parsing to fail", name)
+		}
+	}
+
+	// a caa tag ending past 255 bytes into the rdata
+	b, _ := hex.DecodeString(header + question + "c00c010100010000012c0100" + "00fe" + strings.Repeat("61", 254))
+	msg, err := Parse(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if got := len(msg.Answers[0].RData.(CAA).Tag); got != 254 {
+		t.Fatalf("expected a caa tag of 254 bytes but got %d", got)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, packet := range []string{
+		"00160100000100000000000003646e7306676f6f676c6503636f6d0000010001",
+		"123481800001000500010001076578616d706c6503636f6d0000ff000103777777c00c000500010000012c0002c00cc00c000100010000012c00045db8d822c00c000f00010000012c0009000a046d61696cc00cc00c001000010000012c00120b763d73706631202d616c6c056122625c63045f736970045f746370c00c002100010000012c000c000a003c13c403736970c00cc00c0006000100000e100021026e73c00c0561646d696ec00c78a3f17500001c2000000e10001275000000012c026e73c00c001c00010000012c001020010db8000000000000000000000001",
+		"123481800001000000000000" + "03777777c01200010001" + "03636f6dc00c",
+	} {
+		b, _ := hex.DecodeString(packet)
+		f.Add(b)
+	}
+	for _, rr := range []struct {
+		typ  uint16
+		text string
+	}{
+		{RecordTypeHTTPS, `1 . alpn="h3,h2" port=443 ipv4hint=192.0.2.1 ech=AEX+DQ== ipv6hint=2001:db8::1`},
+		{RecordTypeSVCB, `1 doh.example.net. mandatory=alpn alpn=h2 no-default-alpn dohpath="/dns-query{?dns}" ohttp key65000="a b"`},
+		{RecordTypeCAA, `0 issue "letsencrypt.org"`},
+		{RecordTypeTLSA, `3 1 1 2BB183AF2A40C3B6D6D1C1BD27C2E03E`},
+		{RecordTypeSSHFP, `4 2 1F9C0E6F0B4D0E7E83B3A1E4A3FA7E3A`},
+		{RecordTypeNAPTR, `100 10 "S" "SIP+D2U" "" _sip._udp.example.com.`},
+		{RecordTypeURI, `10 1 "ftp://ftp.example.com/public"`},
+	} {
+		rdata, err := parseRData(rr.typ, rr.text)
+		if err != nil {
+			f.Fatalf("invalid seed %s: %s", rr.text, err)
+		}
+		msg := NewMessage(WithQuestion("example.com", rr.typ, RecordClassIN))
+		msg.Answers = []ResourceRecord{{Name: NewDomainName("example.com"), Type: rr.typ, Class: RecordClassIN, TTL: 300, RData: rdata}}
+		b, _ := msg.Pack()
+		f.Add(b)
+	}
+	edns, _ := NewMessage(
+		WithQuestion("example.com", RecordTypeA, RecordClassIN),
+		WithEDNS(1232, true),
+		WithEDNSOptions(NSID{Data: []byte("ns1")}, Cookie{Client: []byte("abcdefgh")}),
+	).Pack()
+	f.Add(edns)
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		msg, err := Parse(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		_ = msg.String()
+		if _, err := json.Marshal(msg); err != nil {
+			t.Fatalf("expected parsed message to encode as json but got %s", err)
+		}
+		packed, err := msg.Pack()
+		if err != nil {
+			t.Fatalf("expected parsed message to pack but got %s", err)
+		}
+		if _, err := Parse(bytes.NewReader(packed)); err != nil {
+			t.Fatalf("expected packed message to parse but got %s", err)
+		}
+	})
+}
+
+func Test_unknownType(t *testing.T) {
+	// an answer of type 65534 followed by an A record
+	packet, _ := hex.DecodeString("123481800001000200000000" + "076578616d706c6503636f6d0000010001" +
+		"c00cfffe00010000012c0005c00c0a0b0c" + "c00c000100010000012c00045db8d822")
+	msg, err := Parse(bytes.NewReader(packet))
+	if err != nil {
+		t.Fatalf("error parsing message %s", err)
+	}
+
+	want := Unknown{RRType: 65534, Data: []byte{0xc0, 0x0c, 0x0a, 0x0b, 0x0c}}
+	if got := msg.Answers[0].RData; !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected rdata to be %v but got %v", want, got)
+	}
+	if want, got := "example.com.\t300\tIN\tTYPE65534\t\\# 5 c00c0a0b0c", msg.Answers[0].String(); want != got {
+		t.Fatalf("expected record to print as %q but got %q", want, got)
+	}
+	if packed, _ := msg.Pack(); !bytes.Equal(packet, packed) {
+		t.Fatalf("expected message to pack to\n%x\nbut got\n%x\n", packet, packed)
+	}
+
+	// the generic form is accepted for known types too
+	rdata, err := parseRData(RecordTypeA, `\# 4 5db8 d822`)
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if want := (A{Addr: netip.MustParseAddr("93.184.216.34")}); rdata != want {
+		t.Fatalf("expected rdata to be %v but got %v", want, rdata)
+	}
+	if _, err := parseRData(65534, `\# 3 0a0b`); err == nil {
+		t.Fatalf("expected a length that doesn't match the data to fail")
+	}
+}
+
+func Test_modernTypes(t *testing.T) {
+	tests := []struct {
+		typ  uint16
+		text string
+	}{
+		{RecordTypeHTTPS, `1 . alpn="h3,h2" ipv4hint=192.0.2.1,192.0.2.2 ech=AEX+DQ== ipv6hint=2001:db8::1`},
+		{RecordTypeHTTPS, `0 svc.example.com.`},
+		{RecordTypeSVCB, `1 doh.example.net. mandatory=alpn,port alpn="f\\\\oo\\,bar,h2" no-default-alpn port=8443 dohpath="/dns-query{?dns}" ohttp key65000="a b"`},
+		{RecordTypeCAA, `128 issue "letsencrypt.org; validationmethods=dns-01"`},
+		{RecordTypeTLSA, `3 1 1 2BB183AF2A40C3B6D6D1C1BD27C2E03E3E0F4E2C23B44DBF9A2A62ADBB8EE4A0`},
+		{RecordTypeSSHFP, `4 2 1F9C0E6F0B4D0E7E83B3A1E4A3FA7E3A1D4F5CF2C7D6B3A9E2F1C0B9A8D7E6F5`},
+		{RecordTypeNAPTR, `100 10 "S" "SIP+D2U" "" _sip._udp.example.com.`},
+		{RecordTypeURI, `10 1 "ftp://ftp.example.com/public"`},
+	}
+
+	for _, tt := range tests {
+		rdata, err := parseRData(tt.typ, tt.text)
+		if err != nil {
+			t.Fatalf("%s %s: expected no error but got %s", TypeString(tt.typ), tt.text, err)
+		}
+		if got := rdata.String(); tt.text != got {
+			t.Fatalf("expected %s rdata to print as\n%s\nbut got\n%s\n", TypeString(tt.typ), tt.text, got)
+		}
+
+		e := newEncoder(false)
+		rdata.pack(e)
+		unpacked, err := unpackRData(tt.typ, newWireReader(e.buf), len(e.buf))
+		if err != nil {
+			t.Fatalf("%s %s: expected no error but got %s", TypeString(tt.typ), tt.text, err)
+		}
+		if !reflect.DeepEqual(rdata, unpacked) {
+			t.Fatalf("expected %s rdata to unpack to\n%#v\nbut got\n%#v\n", TypeString(tt.typ), rdata, unpacked)
+		}
+	}
+
+	// what wouldn't unpack again isn't read either
+	for _, tt := range []struct {
+		typ  uint16
+		text string
+	}{
+		{RecordTypeHTTPS, `1 . alpn=h2,,h3`},
+		{RecordTypeSVCB, `1 . alpn=""`},
+		{RecordTypeCAA, `0 "" "x"`},
+	} {
+		if _, err := parseRData(tt.typ, tt.text); err == nil {
+			t.Fatalf("expected %s %s to fail", TypeString(tt.typ), tt.text)
+		}
+	}
+	e := newEncoder(false)
+	CAA{Value: "x"}.pack(e)
+	if e.err == nil {
+		t.Fatalf("expected a caa record without a tag not to pack")
+	}
+
+	rdata, _ := parseRData(RecordTypeHTTPS, `1 . port=443 alpn=h2,h3 ipv4hint=192.0.2.1`)
+	https := rdata.(HTTPS)
+	if want, got := []string{"h2", "h3"}, https.ALPN(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected alpn %v but got %v", want, got)
+	}
+	if port, ok := https.Port(); !ok || port != 443 {
+		t.Fatalf("expected port 443 but got %d", port)
+	}
+	if want, got := []netip.Addr{netip.MustParseAddr("192.0.2.1")}, https.IPv4Hint(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected ipv4hint %v but got %v", want, got)
+	}
+	if https.ECH() != nil || https.IPv6Hint() != nil {
+		t.Fatalf("expected no ech nor ipv6hint")
+	}
+
+	// params out of order are rejected on the wire
+	packet, _ := hex.DecodeString("0001" + "00" + "0003000201bb" + "000100030268" + "33")
+	if _, err := unpackRData(RecordTypeHTTPS, newWireReader(packet), len(packet)); err == nil {
+		t.Fatalf("expected params out of order to fail")
+	}
+}
+
+func Test_wireLimits(t *testing.T) {
+	label := strings.Repeat("a", 63)
+	tests := map[string]struct {
+		name string
+		txt  string
+		ok   bool
+	}{
+		"longest label":           {name: label + ".com", ok: true},
+		"label of 64 bytes":       {name: label + "a.com"},
+		"empty label":             {name: "www..com"},
+		"longest name":            {name: strings.Join([]string{label, label, label, strings.Repeat("a", 61)}, "."), ok: true},
+		"name of 256 bytes":       {name: strings.Join([]string{label, label, label, strings.Repeat("a", 62)}, ".")},
+		"longest txt string":      {name: "example.com", txt: strings.Repeat("a", 255), ok: true},
+		"txt string of 256 bytes": {name: "example.com", txt: strings.Repeat("a", 256)},
+	}
+
+	for name, tt := range tests {
+		msg := NewMessage(WithQuestion(tt.name, RecordTypeTXT, RecordClassIN))
+		if tt.txt != "" {
+			msg.Answers = []ResourceRecord{{Name: NewDomainName(tt.name), Type: RecordTypeTXT, Class: RecordClassIN, RData: TXT{Strings: []string{tt.txt}}}}
+		}
+		if _, err := msg.Pack(); (err == nil) != tt.ok {
+			t.Fatalf("%s: expected packing to succeed %t but got %v", name, tt.ok, err)
+		}
+		if _, err := msg.Bytes(); tt.txt == "" && (err == nil) != tt.ok {
+			t.Fatalf("%s: expected encoding to succeed %t but got %v", name, tt.ok, err)
+		}
+
+		var buf bytes.Buffer
+		rr := ResourceRecord{Name: NewDomainName(tt.name), Type: RecordTypeTXT, Class: RecordClassIN, RData: TXT{Strings: []string{tt.txt}}}
+		if _, err := rr.WriteTo(&buf); (err == nil) != tt.ok || (err != nil && buf.Len() != 0) {
+			t.Fatalf("%s: expected writing the record to succeed %t but got %v after %d bytes", name, tt.ok, err, buf.Len())
+		}
+	}
+
+	if _, err := parseRData(RecordTypeTXT, `"`+strings.Repeat("a", 300)+`"`); err == nil {
+		t.Fatalf("expected a txt string of 300 bytes to be rejected")
+	}
+	in := `{"NAME": "` + label + `a.example.com.", "TYPE": 1, "CLASS": 1, "TTL": 300, "rdataA": "192.0.2.1"}`
+	if err := json.Unmarshal([]byte(in), &ResourceRecord{}); err == nil {
+		t.Fatalf("expected a label of 64 bytes to be rejected")
+	}
+
+	// addresses of the wrong family fail instead of panicking
+	for _, rdata := range []RData{
+		A{}, A{Addr: netip.MustParseAddr("::1")}, AAAA{}, AAAA{Addr: netip.MustParseAddr("192.0.2.1")},
+		HTTPS{SVCB{Priority: 1, Target: NewDomainName(""), Params: []SvcParam{SvcIPv4Hint{Addrs: []netip.Addr{netip.MustParseAddr("2001:db8::1")}}}}},
+		SVCB{Priority: 1, Target: NewDomainName(""), Params: []SvcParam{SvcIPv6Hint{Addrs: []netip.Addr{{}}}}},
+	} {
+		msg := NewMessage(WithQuestion("example.com", rdata.Type(), RecordClassIN))
+		msg.Answers = []ResourceRecord{{Name: NewDomainName("example.com"), Type: rdata.Type(), Class: RecordClassIN, RData: rdata}}
+		if _, err := msg.Pack(); err == nil {
+			t.Fatalf("expected packing %s %v to fail", TypeString(rdata.Type()), rdata)
+		}
+		if _, err := json.Marshal(msg); err == nil {
+			t.Fatalf("expected encoding %s %v as json to fail", TypeString(rdata.Type()), rdata)
+		}
+	}
+
+	transport := NewMemoryTransport()
+	r := NewResolver(WithTransport(transport))
+	if _, err := r.Resolve(context.Background(), label+"a.com", RecordTypeA); err == nil {
+		t.Fatalf("expected a label of 64 bytes to be rejected")
+	}
+	if queries := transport.Queries(); len(queries) != 0 {
+		t.Fatalf("expected no queries but got %v", queries)
+	}
+}
@@ -0,0 +1,606 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// RData is the type specific part of a resource record. Callers type
+// switch on the concrete types, A, AAAA, NS, CNAME, SOA, PTR, MX, TXT, SRV,
+// NAPTR, OPT, SSHFP, TLSA, SVCB, HTTPS, URI and CAA, or Unknown for the
+// types without one of their own.
+type RData interface {
+	// Type returns the record type the rdata belongs to.
+	Type() uint16
+	// String returns the rdata in presentation format.
+	String() string
+
+	pack(e *encoder)
+}
+
+// unpackRData reads length bytes of rdata of record type typ from r.
+func unpackRData(typ uint16, r wireReader, length int) (RData, error) {
+	switch typ {
+	case RecordTypeA:
+		return unpackA(r, length)
+	case RecordTypeNS:
+		host, err := parseDomainName(r)
+		return NS{Host: host}, err
+	case RecordTypeCNAME:
+		target, err := parseDomainName(r)
+		return CNAME{Target: target}, err
+	case RecordTypeSOA:
+		return unpackSOA(r)
+	case RecordTypePTR:
+		host, err := parseDomainName(r)
+		return PTR{Host: host}, err
+	case RecordTypeMX:
+		return unpackMX(r)
+	case RecordTypeTXT:
+		return unpackTXT(r, length)
+	case RecordTypeAAAA:
+		return unpackAAAA(r, length)
+	case RecordTypeSRV:
+		return unpackSRV(r)
+	case RecordTypeNAPTR:
+		return unpackNAPTR(r)
+	case RecordTypeOPT:
+		return unpackOPT(r, length)
+	case RecordTypeSSHFP:
+		return unpackSSHFP(r, length)
+	case RecordTypeTLSA:
+		return unpackTLSA(r, length)
+	case RecordTypeSVCB:
+		return unpackSVCB(r, length)
+	case RecordTypeHTTPS:
+		svcb, err := unpackSVCB(r, length)
+		return HTTPS{SVCB: svcb}, err
+	case RecordTypeURI:
+		return unpackURI(r, length)
+	case RecordTypeCAA:
+		return unpackCAA(r, length)
+	default:
+		data, err := read(r, length)
+		if err != nil {
+			return nil, err
+		}
+		return Unknown{RRType: typ, Data: data}, nil
+	}
+}
+
+type A struct {
+	Addr netip.Addr
+}
+
+func unpackA(r wireReader, length int) (RData, error) {
+	if length != 4 {
+		return nil, fmt.Errorf("a rdata is %d bytes long instead of 4", length)
+	}
+	buf, err := read(r, 4)
+	if err != nil {
+		return nil, err
+	}
+	return A{Addr: netip.AddrFrom4([4]byte(buf))}, nil
+}
+
+func (a A) Type() uint16 {
+	return RecordTypeA
+}
+
+func (a A) String() string {
+	return a.Addr.String()
+}
+
+func (a A) pack(e *encoder) {
+	e.ipv4(a.Addr)
+}
+
+type AAAA struct {
+	Addr netip.Addr
+}
+
+func unpackAAAA(r wireReader, length int) (RData, error) {
+	if length != 16 {
+		return nil, fmt.Errorf("aaaa rdata is %d bytes long instead of 16", length)
+	}
+	buf, err := read(r, 16)
+	if err != nil {
+		return nil, err
+	}
+	return AAAA{Addr: netip.AddrFrom16([16]byte(buf))}, nil
+}
+
+func (a AAAA) Type() uint16 {
+	return RecordTypeAAAA
+}
+
+func (a AAAA) String() string {
+	return a.Addr.String()
+}
+
+func (a AAAA) pack(e *encoder) {
+	e.ipv6(a.Addr)
+}
+
+type NS struct {
+	Host DomainName
+}
+
+func (ns NS) Type() uint16 {
+	return RecordTypeNS
+}
+
+func (ns NS) String() string {
+	return ns.Host.fqdn()
+}
+
+func (ns NS) pack(e *encoder) {
+	e.name(ns.Host, true)
+}
+
+type CNAME struct {
+	Target DomainName
+}
+
+func (c CNAME) Type() uint16 {
+	return RecordTypeCNAME
+}
+
+func (c CNAME) String() string {
+	return c.Target.fqdn()
+}
+
+func (c CNAME) pack(e *encoder) {
+	e.name(c.Target, true)
+}
+
+type PTR struct {
+	Host DomainName
+}
+
+func (p PTR) Type() uint16 {
+	return RecordTypePTR
+}
+
+func (p PTR) String() string {
+	return p.Host.fqdn()
+}
+
+func (p PTR) pack(e *encoder) {
+	e.name(p.Host, true)
+}
+
+type SOA struct {
+	MName   DomainName
+	RName   DomainName
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32
+}
+
+func unpackSOA(r wireReader) (RData, error) {
+	mName, err := parseDomainName(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading mname: %w", err)
+	}
+	rName, err := parseDomainName(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading rname: %w", err)
+	}
+	buf, err := read(r, int(soaFixedLength))
+	if err != nil {
+		return nil, err
+	}
+	return SOA{
+		MName:   mName,
+		RName:   rName,
+		Serial:  binary.BigEndian.Uint32(buf[0:4]),
+		Refresh: binary.BigEndian.Uint32(buf[4:8]),
+		Retry:   binary.BigEndian.Uint32(buf[8:12]),
+		Expire:  binary.BigEndian.Uint32(buf[12:16]),
+		Minimum: binary.BigEndian.Uint32(buf[16:20]),
+	}, nil
+}
+
+func (s SOA) Type() uint16 {
+	return RecordTypeSOA
+}
+
+func (s SOA) String() string {
+	return fmt.Sprintf("%s %s %d %d %d %d %d",
+		s.MName.fqdn(),
+		s.RName.fqdn(),
+		s.Serial,
+		s.Refresh,
+		s.Retry,
+		s.Expire,
+		s.Minimum,
+	)
+}
+
+func (s SOA) pack(e *encoder) {
+	e.name(s.MName, true)
+	e.name(s.RName, true)
+	e.uint32(s.Serial)
+	e.uint32(s.Refresh)
+	e.uint32(s.Retry)
+	e.uint32(s.Expire)
+	e.uint32(s.Minimum)
+}
+
+type MX struct {
+	Preference uint16
+	Exchange   DomainName
+}
+
+func unpackMX(r wireReader) (RData, error) {
+	buf, err := read(r, 2)
+	if err != nil {
+		return nil, err
+	}
+	exchange, err := parseDomainName(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading exchange: %w", err)
+	}
+	return MX{
+		Preference: binary.BigEndian.Uint16(buf),
+		Exchange:   exchange,
+	}, nil
+}
+
+func (mx MX) Type() uint16 {
+	return RecordTypeMX
+}
+
+func (mx MX) String() string {
+	return fmt.Sprintf("%d %s", mx.Preference, mx.Exchange.fqdn())
+}
+
+func (mx MX) pack(e *encoder) {
+	e.uint16(mx.Preference)
+	e.name(mx.Exchange, true)
+}
+
+type TXT struct {
+	Strings []string
+}
+
+func unpackTXT(r wireReader, length int) (RData, error) {
+	strs := []string{}
+	for consumed := 0; consumed < length; {
+		lengthBuf, err := read(r, 1)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, lengthBuf[0])
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		strs = append(strs, string(buf))
+		consumed += 1 + len(buf)
+	}
+	return TXT{Strings: strs}, nil
+}
+
+func (t TXT) Type() uint16 {
+	return RecordTypeTXT
+}
+
+func (t TXT) String() string {
+	quoted := make([]string, 0, len(t.Strings))
+	for _, s := range t.Strings {
+		quoted = append(quoted, quoteCharacterString(s))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (t TXT) pack(e *encoder) {
+	for _, s := range t.Strings {
+		e.characterString(s)
+	}
+}
+
+type SRV struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   DomainName
+}
+
+func unpackSRV(r wireReader) (RData, error) {
+	buf, err := read(r, 6)
+	if err != nil {
+		return nil, err
+	}
+	target, err := parseDomainName(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading target: %w", err)
+	}
+	return SRV{
+		Priority: binary.BigEndian.Uint16(buf[0:2]),
+		Weight:   binary.BigEndian.Uint16(buf[2:4]),
+		Port:     binary.BigEndian.Uint16(buf[4:6]),
+		Target:   target,
+	}, nil
+}
+
+func (s SRV) Type() uint16 {
+	return RecordTypeSRV
+}
+
+func (s SRV) String() string {
+	return fmt.Sprintf("%d %d %d %s", s.Priority, s.Weight, s.Port, s.Target.fqdn())
+}
+
+// pack writes the target uncompressed as RFC 2782 requires.
+func (s SRV) pack(e *encoder) {
+	e.uint16(s.Priority)
+	e.uint16(s.Weight)
+	e.uint16(s.Port)
+	e.name(s.Target, false)
+}
+
+type NAPTR struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Services    string
+	Regexp      string
+	Replacement DomainName
+}
+
+func unpackNAPTR(r wireReader) (RData, error) {
+	buf, err := read(r, 4)
+	if err != nil {
+		return nil, err
+	}
+	strs := [3]string{}
+	for i := range strs {
+		if strs[i], err = readCharacterString(r); err != nil {
+			return nil, err
+		}
+	}
+	replacement, err := parseDomainName(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading replacement: %w", err)
+	}
+	return NAPTR{
+		Order:       binary.BigEndian.Uint16(buf[0:2]),
+		Preference:  binary.BigEndian.Uint16(buf[2:4]),
+		Flags:       strs[0],
+		Services:    strs[1],
+		Regexp:      strs[2],
+		Replacement: replacement,
+	}, nil
+}
+
+func (n NAPTR) Type() uint16 {
+	return RecordTypeNAPTR
+}
+
+func (n NAPTR) String() string {
+	return fmt.Sprintf("%d %d %s %s %s %s", n.Order, n.Preference,
+		quoteCharacterString(n.Flags), quoteCharacterString(n.Services), quoteCharacterString(n.Regexp), n.Replacement.fqdn())
+}
+
+// pack writes the replacement uncompressed as RFC 3597 requires.
+func (n NAPTR) pack(e *encoder) {
+	e.uint16(n.Order)
+	e.uint16(n.Preference)
+	e.characterString(n.Flags)
+	e.characterString(n.Services)
+	e.characterString(n.Regexp)
+	e.name(n.Replacement, false)
+}
+
+// SSHFP is the fingerprint of an SSH host key, RFC 4255.
+type SSHFP struct {
+	Algorithm   uint8
+	FPType      uint8
+	Fingerprint []byte
+}
+
+func unpackSSHFP(r wireReader, length int) (RData, error) {
+	buf, err := read(r, length)
+	if err != nil {
+		return nil, err
+	}
+	if length < 2 {
+		return nil, fmt.Errorf("sshfp rdata is %d bytes long, shorter than 2", length)
+	}
+	return SSHFP{Algorithm: buf[0], FPType: buf[1], Fingerprint: buf[2:]}, nil
+}
+
+func (s SSHFP) Type() uint16 {
+	return RecordTypeSSHFP
+}
+
+func (s SSHFP) String() string {
+	return fmt.Sprintf("%d %d %s", s.Algorithm, s.FPType, strings.ToUpper(hex.EncodeToString(s.Fingerprint)))
+}
+
+func (s SSHFP) pack(e *encoder) {
+	e.bytes([]byte{s.Algorithm, s.FPType})
+	e.bytes(s.Fingerprint)
+}
+
+// TLSA associates a certificate or public key with a TLS service for DANE,
+// RFC 6698.
+type TLSA struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	CertData     []byte
+}
+
+func unpackTLSA(r wireReader, length int) (RData, error) {
+	buf, err := read(r, length)
+	if err != nil {
+		return nil, err
+	}
+	if length < 3 {
+		return nil, fmt.Errorf("tlsa rdata is %d bytes long, shorter than 3", length)
+	}
+	return TLSA{Usage: buf[0], Selector: buf[1], MatchingType: buf[2], CertData: buf[3:]}, nil
+}
+
+func (t TLSA) Type() uint16 {
+	return RecordTypeTLSA
+}
+
+func (t TLSA) String() string {
+	return fmt.Sprintf("%d %d %d %s", t.Usage, t.Selector, t.MatchingType, strings.ToUpper(hex.EncodeToString(t.CertData)))
+}
+
+func (t TLSA) pack(e *encoder) {
+	e.bytes([]byte{t.Usage, t.Selector, t.MatchingType})
+	e.bytes(t.CertData)
+}
+
+// URI maps a service name to a URI, RFC 7553.
+type URI struct {
+	Priority uint16
+	Weight   uint16
+	Target   string
+}
+
+func unpackURI(r wireReader, length int) (RData, error) {
+	buf, err := read(r, length)
+	if err != nil {
+		return nil, err
+	}
+	if length < 4 {
+		return nil, fmt.Errorf("uri rdata is %d bytes long, shorter than 4", length)
+	}
+	return URI{
+		Priority: binary.BigEndian.Uint16(buf[0:2]),
+		Weight:   binary.BigEndian.Uint16(buf[2:4]),
+		Target:   string(buf[4:]),
+	}, nil
+}
+
+func (u URI) Type() uint16 {
+	return RecordTypeURI
+}
+
+func (u URI) String() string {
+	return fmt.Sprintf("%d %d %s", u.Priority, u.Weight, quoteCharacterString(u.Target))
+}
+
+func (u URI) pack(e *encoder) {
+	e.uint16(u.Priority)
+	e.uint16(u.Weight)
+	e.bytes([]byte(u.Target))
+}
+
+// CAA restricts the certificate authorities that may issue certificates
+// for a domain, RFC 8659. Tag is one of issue, issuewild and iodef.
+type CAA struct {
+	Flags uint8
+	Tag   string
+	Value string
+}
+
+func unpackCAA(r wireReader, length int) (RData, error) {
+	buf, err := read(r, length)
+	if err != nil {
+		return nil, err
+	}
+	if length < 2 || buf[1] == 0 || 2+int(buf[1]) > length {
+		return nil, fmt.Errorf("caa rdata of %d bytes has no room for its tag", length)
+	}
+	end := 2 + int(buf[1])
+	return CAA{
+		Flags: buf[0],
+		Tag:   string(buf[2:end]),
+		Value: string(buf[end:]),
+	}, nil
+}
+
+func (c CAA) Type() uint16 {
+	return RecordTypeCAA
+}
+
+// Critical reports whether the issuer critical flag is set, which makes
+// authorities that don't understand Tag refuse to issue.
+func (c CAA) Critical() bool {
+	return c.Flags&0x80 != 0
+}
+
+func (c CAA) String() string {
+	return fmt.Sprintf("%d %s %s", c.Flags, c.Tag, quoteCharacterString(c.Value))
+}
+
+func (c CAA) pack(e *encoder) {
+	if c.Tag == "" {
+		e.fail(fmt.Errorf("caa tag can't be empty"))
+	}
+	e.bytes([]byte{c.Flags})
+	e.characterString(c.Tag)
+	e.bytes([]byte(c.Value))
+}
+
+// readCharacterString reads a <character-string>, a length byte followed
+// by as many bytes.
+func readCharacterString(r io.Reader) (string, error) {
+	lengthBuf, err := read(r, 1)
+	if err != nil {
+		return "", err
+	}
+	buf, err := read(r, int(lengthBuf[0]))
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// quoteCharacterString returns s as a quoted <character-string>, escaping
+// quotes and backslashes and writing non printable bytes as \DDD.
+func quoteCharacterString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&sb, "\\%03d", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// Unknown is the rdata of a type without a dedicated one, kept as it came
+// off the wire, RFC 3597.
+type Unknown struct {
+	RRType uint16
+	Data   []byte
+}
+
+func (u Unknown) Type() uint16 {
+	return u.RRType
+}
+
+// String returns the rdata in the generic form of RFC 3597, such as
+// \# 4 0a000001.
+func (u Unknown) String() string {
+	if len(u.Data) == 0 {
+		return `\# 0`
+	}
+	return fmt.Sprintf(`\# %d %s`, len(u.Data), hex.EncodeToString(u.Data))
+}
+
+func (u Unknown) pack(e *encoder) {
+	e.bytes(u.Data)
+}
@@ -0,0 +1,730 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultPort    uint16 = 53
+	defaultTimeout        = 2 * time.Second
+	defaultRetries        = 2
+
+	defaultMaxCNAMEChain = 8
+	defaultMaxDepth      = 4
+)
+
+// Resolver iteratively resolves names starting from a set of root hints.
+// A Resolver is safe for concurrent use once constructed.
+type Resolver struct {
+	rootHints []netip.Addr
+	port      uint16
+	timeout   time.Duration
+	retries   int
+	cache     *Cache
+	ipv4      bool
+	ipv6      bool
+
+	maxCNAMEChain int
+	maxDepth      int
+	ednsSize      uint16
+	tcpOnly       bool
+	transport     Transport
+	trace         func(TraceStep)
+	forwarders    []netip.Addr
+
+	rejected atomic.Uint64
+}
+
+type ResolverOptsFunc func(*Resolver)
+
+// WithRootHints replaces the root servers resolution starts from.
+func WithRootHints(addrs ...netip.Addr) ResolverOptsFunc {
+	return func(r *Resolver) {
+		r.rootHints = slices.Clone(addrs)
+	}
+}
+
+// WithPort sets the port nameservers are queried on.
+func WithPort(port uint16) ResolverOptsFunc {
+	return func(r *Resolver) {
+		r.port = port
+	}
+}
+
+// WithTimeout sets how long a single query waits for a response.
+func WithTimeout(d time.Duration) ResolverOptsFunc {
+	return func(r *Resolver) {
+		r.timeout = d
+	}
+}
+
+// WithRetries sets how many more times the nameservers of a zone are
+// queried after all of them failed to answer.
+func WithRetries(n int) ResolverOptsFunc {
+	return func(r *Resolver) {
+		r.retries = n
+	}
+}
+
+// WithCache makes the resolver store answers and delegations in c, which
+// may be shared between resolvers. A nil cache disables caching.
+func WithCache(c *Cache) ResolverOptsFunc {
+	return func(r *Resolver) {
+		r.cache = c
+	}
+}
+
+// WithIPv4 sets whether nameservers are queried over IPv4.
+func WithIPv4(enabled bool) ResolverOptsFunc {
+	return func(r *Resolver) {
+		r.ipv4 = enabled
+	}
+}
+
+// WithIPv6 sets whether nameservers are queried over IPv6, using the AAAA
+// glue of delegations.
+func WithIPv6(enabled bool) ResolverOptsFunc {
+	return func(r *Resolver) {
+		r.ipv6 = enabled
+	}
+}
+
+// WithMaxCNAMEChain sets how many CNAMEs are followed before resolution
+// is given up.
+func WithMaxCNAMEChain(n int) ResolverOptsFunc {
+	return func(r *Resolver) {
+		r.maxCNAMEChain = n
+	}
+}
+
+// WithMaxDepth sets how deeply lookups of nameservers without glue may
+// nest, each of which can need nameservers without glue of its own.
+func WithMaxDepth(n int) ResolverOptsFunc {
+	return func(r *Resolver) {
+		r.maxDepth = n
+	}
+}
+
+// WithEDNSBufferSize sets the UDP payload size advertised in the OPT record
+// of queries. Zero sends queries without EDNS.
+func WithEDNSBufferSize(size uint16) ResolverOptsFunc {
+	return func(r *Resolver) {
+		r.ednsSize = size
+	}
+}
+
+// WithTCPOnly makes the resolver query nameservers over TCP only, for
+// networks that filter DNS over UDP. It has no effect along with
+// WithTransport.
+func WithTCPOnly(enabled bool) ResolverOptsFunc {
+	return func(r *Resolver) {
+		r.tcpOnly = enabled
+	}
+}
+
+// WithTransport replaces the network as the way queries reach nameservers,
+// MemoryTransport allowing resolution to be tested offline.
+func WithTransport(t Transport) ResolverOptsFunc {
+	return func(r *Resolver) {
+		r.transport = t
+	}
+}
+
+// WithForwarders makes the resolver send queries with recursion desired
+// to the recursive resolvers at addrs instead of resolving iteratively from
+// the root hints.
+func WithForwarders(addrs ...netip.Addr) ResolverOptsFunc {
+	return func(r *Resolver) {
+		r.forwarders = slices.Clone(addrs)
+	}
+}
+
+// WithTrace makes the resolver call trace with every query it sends, in
+// order, from the goroutine resolving.
+func WithTrace(trace func(TraceStep)) ResolverOptsFunc {
+	return func(r *Resolver) {
+		r.trace = trace
+	}
+}
+
+func NewResolver(opts ...ResolverOptsFunc) *Resolver {
+	r := &Resolver{
+		rootHints: DefaultRootHints,
+		port:      defaultPort,
+		timeout:   defaultTimeout,
+		retries:   defaultRetries,
+		cache:     NewCache(defaultCacheSize),
+		ipv4:      true,
+		ipv6:      true,
+
+		maxCNAMEChain: defaultMaxCNAMEChain,
+		maxDepth:      defaultMaxDepth,
+		ednsSize:      DefaultEDNSBufferSize,
+	}
+
+	for _, f := range opts {
+		f(r)
+	}
+	if r.transport == nil {
+		r.transport = &NetTransport{TCPOnly: r.tcpOnly}
+	}
+
+	return r
+}
+
+// Resolve iteratively resolves records of type qtype for name, starting
+// from the root hints and following CNAMEs into other zones. It returns
+// ErrNXDomain, ErrNoData, ErrServerFailure, ErrTimeout, ErrMalformedResponse,
+// ErrLameDelegation, ErrCNAMELoop or ErrCNAMEChainTooLong (wrapped) when
+// resolution fails.
+func (r *Resolver) Resolve(ctx context.Context, name string, qtype uint16) (Result, error) {
+	return r.resolve(ctx, name, qtype, &resolution{pending: map[string]bool{}})
+}
+
+// LookupAddrs resolves the addresses of name for each of qtypes, which
+// should be RecordTypeA and/or RecordTypeAAAA. It only fails when none of
+// the lookups produced an address, with the error of the first lookup.
+func (r *Resolver) LookupAddrs(ctx context.Context, name string, qtypes ...uint16) ([]netip.Addr, error) {
+	return r.lookupAddrs(ctx, name, qtypes, &resolution{pending: map[string]bool{}})
+}
+
+func (r *Resolver) lookupAddrs(ctx context.Context, name string, qtypes []uint16, res *resolution) ([]netip.Addr, error) {
+	addrs := []netip.Addr{}
+	var firstErr error
+	for _, qtype := range qtypes {
+		result, err := r.resolve(ctx, name, qtype, res)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		addrs = append(addrs, result.Addrs()...)
+	}
+
+	if len(addrs) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return addrs, nil
+}
+
+// resolution carries the state shared by Resolve and the lookups of
+// nameserver addresses it starts.
+type resolution struct {
+	depth   int
+	pending map[string]bool
+}
+
+func (r *Resolver) resolve(ctx context.Context, name string, qtype uint16, res *resolution) (Result, error) {
+	name = strings.TrimSuffix(name, ".")
+	if err := NewDomainName(name).check(); err != nil {
+		return Result{}, fmt.Errorf("invalid name: %w", err)
+	}
+	result := Result{
+		Name:    name,
+		Type:    qtype,
+		Chain:   []ResourceRecord{},
+		Answers: []ResourceRecord{},
+	}
+
+	target := name
+	seen := map[string]bool{strings.ToLower(target): true}
+	for {
+		answers, chain, err := r.resolveOne(ctx, target, qtype, res)
+		for _, cname := range chain {
+			result.Chain = append(result.Chain, cname)
+			if len(result.Chain) > r.maxCNAMEChain {
+				return result, fmt.Errorf("%s: more than %d cnames: %w", name, r.maxCNAMEChain, ErrCNAMEChainTooLong)
+			}
+			target = cname.RData.(CNAME).Target.String()
+			if seen[strings.ToLower(target)] {
+				return result, fmt.Errorf("%s: %s seen twice: %w", name, target, ErrCNAMELoop)
+			}
+			seen[strings.ToLower(target)] = true
+		}
+		if err != nil {
+			return result, err
+		}
+
+		if len(answers) != 0 || len(chain) == 0 {
+			result.Answers = answers
+			return result, nil
+		}
+	}
+}
+
+// resolveOne resolves name without leaving the zone the answer is found
+// in. It returns the records of qtype owned by the end of the chain of
+// CNAMEs present in the answer, which is empty when the final records live
+// elsewhere.
+func (r *Resolver) resolveOne(ctx context.Context, name string, qtype uint16, res *resolution) ([]ResourceRecord, []ResourceRecord, error) {
+	if answers, chain, ok, err := r.cachedAnswer(name, qtype); ok {
+		return answers, chain, err
+	}
+	zone, servers := r.closestServers(name)
+
+	for range maxReferrals {
+		opts := []MessageOptsFunc{
+			WithID(newID()),
+			WithQuestion(name, qtype, RecordClassIN),
+		}
+		if r.ednsSize != 0 {
+			opts = append(opts, WithEDNS(r.ednsSize, false))
+		}
+		if len(r.forwarders) != 0 {
+			opts = append(opts, WithRecursionDesired())
+		}
+		req := NewMessage(opts...)
+		resp, server, err := r.query(ctx, zone, servers, req)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// servers are only trusted for records of the zone they were asked
+		// as nameservers of, the zone a referral names is checked below
+		cut, referral := delegation(resp)
+		resp.Answers = inBailiwick(resp.Answers, zone)
+		resp.Authority = inBailiwick(resp.Authority, zone)
+		resp.Additional = inBailiwick(resp.Additional, zone)
+
+		switch resp.RCode() {
+		case RCodeNoError:
+		case RCodeNXDomain:
+			r.cacheRecords(resp.Answers)
+			_, chain := answerOf(resp, name, qtype)
+			target := name
+			if len(chain) != 0 {
+				target = chain[len(chain)-1].RData.(CNAME).Target.String()
+			}
+			r.cacheNegative(resp, target, qtype, true)
+			return nil, chain, fmt.Errorf("%s: %w", target, ErrNXDomain)
+		default:
+			return nil, nil, fmt.Errorf("%s from %s: %s: %w", name, server, resp.RCode(), ErrServerFailure)
+		}
+
+		// result was found
+		if len(resp.Answers) != 0 {
+			r.cacheRecords(resp.Answers)
+			answers, chain := answerOf(resp, name, qtype)
+			if len(answers) == 0 && len(chain) == 0 {
+				return nil, nil, fmt.Errorf("%s: %w", name, ErrNoData)
+			}
+			return answers, chain, nil
+		}
+
+		// no answer and no referral means the name has no such records, an
+		// authoritative server may list the zone's NS along with that
+		if resp.Header.AA() || !referral {
+			r.cacheNegative(resp, name, qtype, false)
+			return nil, nil, fmt.Errorf("%s: %w", name, ErrNoData)
+		}
+
+		// a referral has to lead down towards name, anything else would let
+		// a server take over zones it isn't responsible for
+		if !inZone(name, cut) || !inZone(cut, zone) || sameName(cut, zone) {
+			return nil, nil, fmt.Errorf("%s: referral from %s for zone %q to zone %q: %w", name, server, zone, cut, ErrLameDelegation)
+		}
+		resp.Authority = slices.DeleteFunc(resp.Authority, func(rr ResourceRecord) bool {
+			return rr.Type != RecordTypeNS || !sameName(rr.Name.String(), cut)
+		})
+		resp.Additional = glue(resp)
+		zone = cut
+
+		r.cacheRecords(resp.Authority)
+		r.cacheGlue(resp.Additional)
+		servers = r.referral(resp)
+		if len(servers) == 0 {
+			servers = r.resolveNameservers(ctx, nameservers(resp), res)
+		}
+		if len(servers) == 0 {
+			return nil, nil, fmt.Errorf("%s: no usable referral from %s: %w", name, server, ErrLameDelegation)
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%s: more than %d referrals: %w", name, maxReferrals, ErrLameDelegation)
+}
+
+// resolveNameservers looks up the addresses of nameservers that came
+// without glue, stopping at the first one that has any. Nameservers whose
+// resolution is already under way are skipped to break cycles, and nothing
+// is resolved once the depth budget is spent.
+func (r *Resolver) resolveNameservers(ctx context.Context, nameservers []string, res *resolution) []netip.Addr {
+	if res.depth >= r.maxDepth {
+		return nil
+	}
+
+	for _, ns := range nameservers {
+		key := strings.ToLower(ns)
+		if res.pending[key] {
+			continue
+		}
+
+		res.pending[key] = true
+		res.depth++
+		addrs, _ := r.lookupAddrs(ctx, ns, r.addrTypes(), res)
+		res.depth--
+		delete(res.pending, key)
+		if len(addrs) != 0 {
+			return addrs
+		}
+	}
+	return nil
+}
+
+// addrTypes returns the address record types of the families nameservers
+// are queried over.
+func (r *Resolver) addrTypes() []uint16 {
+	qtypes := []uint16{}
+	if r.ipv4 {
+		qtypes = append(qtypes, RecordTypeA)
+	}
+	if r.ipv6 {
+		qtypes = append(qtypes, RecordTypeAAAA)
+	}
+	return qtypes
+}
+
+// usable reports whether addr is of a family nameservers are queried over.
+func (r *Resolver) usable(addr netip.Addr) bool {
+	if addr.Unmap().Is4() {
+		return r.ipv4
+	}
+	return r.ipv6
+}
+
+// CacheStats returns the counters of the resolver's cache.
+func (r *Resolver) CacheStats() CacheStats {
+	if r.cache == nil {
+		return CacheStats{}
+	}
+	return r.cache.Stats()
+}
+
+// Rejected returns how many responses were discarded for not matching the
+// query they were received for, including those the transport discarded
+// when it counts them.
+func (r *Resolver) Rejected() uint64 {
+	rejected := r.rejected.Load()
+	if t, ok := r.transport.(interface{ Rejected() uint64 }); ok {
+		rejected += t.Rejected()
+	}
+	return rejected
+}
+
+// cachedAnswer looks name up in the cache the way resolveOne would query
+// for it. The error is set when the name is cached as non-existent or
+// without records of qtype. The cache stats count the lookup as one hit or
+// miss, however many entries it probes.
+func (r *Resolver) cachedAnswer(name string, qtype uint16) ([]ResourceRecord, []ResourceRecord, bool, error) {
+	if r.cache == nil {
+		return nil, nil, false, nil
+	}
+
+	answers, chain, ok, err := r.peekAnswer(name, qtype)
+	r.cache.countLookup(ok)
+	return answers, chain, ok, err
+}
+
+// peekAnswer is cachedAnswer without counting in the cache stats.
+func (r *Resolver) peekAnswer(name string, qtype uint16) ([]ResourceRecord, []ResourceRecord, bool, error) {
+	if records, ok := r.cache.peek(name, qtype, RecordClassIN); ok {
+		return records, nil, true, nil
+	}
+	if nxdomain, ok := r.cache.peekNegative(name, qtype, RecordClassIN); ok {
+		if nxdomain {
+			return nil, nil, true, fmt.Errorf("%s: %w", name, ErrNXDomain)
+		}
+		return nil, nil, true, fmt.Errorf("%s: %w", name, ErrNoData)
+	}
+	if qtype != RecordTypeCNAME {
+		if cnames, ok := r.cache.peek(name, RecordTypeCNAME, RecordClassIN); ok {
+			return nil, cnames[:1], true, nil
+		}
+	}
+	return nil, nil, false, nil
+}
+
+// cacheNegative stores a NXDOMAIN or NODATA response for name using the
+// smaller of the TTL and MINIMUM of the SOA in the authority section.
+// Responses without a SOA are not cached.
+func (r *Resolver) cacheNegative(resp Message, name string, qtype uint16, nxdomain bool) {
+	if r.cache == nil {
+		return
+	}
+
+	for _, rr := range resp.Authority {
+		if rr.Type != RecordTypeSOA {
+			continue
+		}
+		ttl := min(rr.TTL, rr.RData.(SOA).Minimum)
+		r.cache.SetNegative(name, qtype, RecordClassIN, nxdomain, ttl)
+		return
+	}
+}
+
+// closestServers returns the closest enclosing zone of name whose
+// nameservers are in the cache along with their addresses, or the root and
+// its hints. Forwarders answer for the root.
+func (r *Resolver) closestServers(name string) (string, []netip.Addr) {
+	if len(r.forwarders) != 0 {
+		return "", r.forwarders
+	}
+	if r.cache == nil {
+		return "", r.rootHints
+	}
+
+	zone := name
+	for zone != "" {
+		nameservers, ok := r.cache.peek(zone, RecordTypeNS, RecordClassIN)
+		if ok {
+			addrs := []netip.Addr{}
+			for _, ns := range nameservers {
+				for _, qtype := range r.addrTypes() {
+					glue, _ := r.cache.getGlue(ns.RData.(NS).Host.String(), qtype, RecordClassIN)
+					for _, rr := range glue {
+						if addr, ok := rr.Addr(); ok {
+							addrs = append(addrs, addr)
+						}
+					}
+				}
+			}
+			if len(addrs) != 0 {
+				return zone, addrs
+			}
+		}
+		_, zone, _ = strings.Cut(zone, ".")
+	}
+	return "", r.rootHints
+}
+
+// cacheRecords stores records of resp in the cache grouped into RRsets.
+func (r *Resolver) cacheRecords(records []ResourceRecord) {
+	if r.cache != nil {
+		cacheRRsets(records, r.cache.Set)
+	}
+}
+
+// cacheGlue stores the addresses of nameservers that came with a referral
+// in the cache, where they are only used to reach those nameservers.
+func (r *Resolver) cacheGlue(records []ResourceRecord) {
+	if r.cache != nil {
+		cacheRRsets(records, r.cache.setGlue)
+	}
+}
+
+func cacheRRsets(records []ResourceRecord, set func(name string, typ uint16, class uint16, records []ResourceRecord)) {
+
+	rrsets := map[cacheKey][]ResourceRecord{}
+	keys := []cacheKey{}
+	for _, rr := range records {
+		if rr.Type == RecordTypeOPT {
+			continue
+		}
+		key := newCacheKey(rr.Name.String(), rr.Type, rr.Class)
+		if _, ok := rrsets[key]; !ok {
+			keys = append(keys, key)
+		}
+		rrsets[key] = append(rrsets[key], rr)
+	}
+	for _, key := range keys {
+		set(key.name, key.typ, key.class, rrsets[key])
+	}
+}
+
+// query sends req to servers in random order until one of them answers,
+// going over the whole list once more for each retry. Servers answering
+// SERVFAIL, REFUSED or NOTIMP are skipped in favour of the next one and
+// not asked again. A server answering FORMERR to a query with EDNS is
+// asked again without it, as older servers reject OPT records.
+func (r *Resolver) query(ctx context.Context, zone string, servers []netip.Addr, req Message) (Message, netip.AddrPort, error) {
+	order := slices.DeleteFunc(slices.Clone(servers), func(addr netip.Addr) bool {
+		return !r.usable(addr)
+	})
+	rand.Shuffle(len(order), func(i, j int) {
+		order[i], order[j] = order[j], order[i]
+	})
+
+	var lastErr error
+	failed := map[netip.Addr]bool{}
+	for attempt := range r.retries + 1 {
+		for _, addr := range order {
+			if err := ctx.Err(); err != nil {
+				return Message{}, netip.AddrPort{}, err
+			}
+			if failed[addr] {
+				continue
+			}
+
+			server := netip.AddrPortFrom(addr, r.port)
+			queryCtx, cancel := context.WithTimeout(ctx, r.timeout)
+			resp, err := r.exchange(queryCtx, zone, attempt, server, req)
+			if _, ok := req.EDNS(); ok && err == nil && resp.RCode() == RCodeFormErr {
+				plain := req
+				plain.Header.ID = newID()
+				plain.RemoveEDNS()
+				resp, err = r.exchange(queryCtx, zone, attempt, server, plain)
+			}
+			cancel()
+			if err == nil {
+				switch resp.RCode() {
+				case RCodeServFail, RCodeRefused, RCodeNotImp:
+					failed[addr] = true
+					lastErr = fmt.Errorf("%s from %s: %s: %w", req.Questions[0].QName, server, resp.RCode(), ErrServerFailure)
+					continue
+				}
+				return resp, server, nil
+			}
+
+			// the caller gave up, no point in trying anyone else
+			if ctx.Err() != nil {
+				return Message{}, netip.AddrPort{}, err
+			}
+			lastErr = err
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no nameservers to query")
+	}
+	return Message{}, netip.AddrPort{}, lastErr
+}
+
+// exchange sends req to server, a nameserver of zone, rejecting a response
+// that doesn't match req whichever transport it came through. The exchange
+// is traced when the resolver is set to.
+func (r *Resolver) exchange(ctx context.Context, zone string, attempt int, server netip.AddrPort, req Message) (resp Message, err error) {
+	if r.trace != nil {
+		start := time.Now()
+		defer func() {
+			r.trace(TraceStep{
+				Server:   server,
+				Zone:     zone,
+				Attempt:  attempt + 1,
+				Query:    req,
+				Response: resp,
+				Latency:  time.Since(start),
+				Err:      err,
+			})
+		}()
+	}
+
+	resp, err = r.transport.Exchange(ctx, server, req)
+	if err != nil {
+		return Message{}, err
+	}
+	if err := checkResponse(req, resp); err != nil {
+		r.rejected.Add(1)
+		return Message{}, fmt.Errorf("%w from %s: %w", ErrMismatchedResponse, server, err)
+	}
+	return resp, nil
+}
+
+// answerOf follows the CNAMEs present in the answer of resp starting at
+// name and collects the records of qtype owned by the last name reached.
+func answerOf(resp Message, name string, qtype uint16) ([]ResourceRecord, []ResourceRecord) {
+	answers := []ResourceRecord{}
+	chain := []ResourceRecord{}
+
+	target := name
+	for range len(resp.Answers) {
+		found := false
+		for _, rr := range resp.Answers {
+			if qtype != RecordTypeCNAME && rr.Type == RecordTypeCNAME && strings.EqualFold(target, rr.Name.String()) {
+				chain = append(chain, rr)
+				target = rr.RData.(CNAME).Target.String()
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	for _, rr := range resp.Answers {
+		if rr.Type == qtype && strings.EqualFold(target, rr.Name.String()) {
+			answers = append(answers, rr)
+		}
+	}
+
+	return answers, chain
+}
+
+// delegation returns the zone resp refers to, the owner of the NS records
+// in its authority section, when resp is a referral rather than denying
+// that records exist.
+func delegation(resp Message) (string, bool) {
+	cut, found := "", false
+	for _, rr := range resp.Authority {
+		switch rr.Type {
+		case RecordTypeSOA:
+			return "", false
+		case RecordTypeNS:
+			if !found {
+				cut, found = rr.Name.String(), true
+			}
+		}
+	}
+	return cut, found
+}
+
+// inBailiwick returns the records of records owned by zone or names below
+// it. OPT records are kept, they don't belong to any zone.
+func inBailiwick(records []ResourceRecord, zone string) []ResourceRecord {
+	kept := []ResourceRecord{}
+	for _, rr := range records {
+		if rr.Type == RecordTypeOPT || inZone(rr.Name.String(), zone) {
+			kept = append(kept, rr)
+		}
+	}
+	return kept
+}
+
+// nameservers returns the names of the nameservers in the authority section
+// of resp.
+func nameservers(resp Message) []string {
+	names := []string{}
+	for _, rr := range resp.Authority {
+		if ns, ok := rr.RData.(NS); ok {
+			names = append(names, ns.Host.String())
+		}
+	}
+	return names
+}
+
+// glue returns the A and AAAA records of the additional section of resp
+// owned by the nameservers in its authority section, dropping whatever
+// else a server added there.
+func glue(resp Message) []ResourceRecord {
+	hosts := nameservers(resp)
+	return slices.DeleteFunc(slices.Clone(resp.Additional), func(rr ResourceRecord) bool {
+		_, ok := rr.Addr()
+		return !ok || !slices.ContainsFunc(hosts, func(host string) bool {
+			return sameName(host, rr.Name.String())
+		})
+	})
+}
+
+// referral returns the addresses of the nameservers in the authority
+// section of resp that have usable glue in the additional section.
+func (r *Resolver) referral(resp Message) []netip.Addr {
+	addrs := []netip.Addr{}
+	for _, ns := range nameservers(resp) {
+		for _, additional := range resp.RecordsOfDomainName(ns) {
+			if addr, ok := additional.Addr(); ok && r.usable(addr) {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,702 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testRoot   = netip.MustParseAddr("198.41.0.4")
+	testGTLD   = netip.MustParseAddr("192.5.6.30")
+	testGoogle = netip.MustParseAddr("216.239.32.10")
+	testPublic = netip.MustParseAddr("8.8.8.8")
+	testExCom  = netip.MustParseAddr("198.51.100.1")
+	testExNet  = netip.MustParseAddr("198.51.100.2")
+)
+
+func testRecord(name string, rdata RData) ResourceRecord {
+	return ResourceRecord{
+		Name:  NewDomainName(name),
+		Type:  rdata.Type(),
+		Class: RecordClassIN,
+		TTL:   300,
+		RData: rdata,
+	}
+}
+
+func testSOA(origin string) ResourceRecord {
+	return testRecord(origin, SOA{
+		MName:   NewDomainName("ns." + origin),
+		RName:   NewDomainName("hostmaster." + origin),
+		Serial:  1,
+		Minimum: 60,
+	})
+}
+
+// testTransport serves a small hierarchy under the root:
+//
+//   - google.com, delegated with glue and also served by 8.8.8.8
+//   - example.com, delegated with glue
+//   - example.net, delegated with glue
+//   - glueless.com, delegated to ns.example.net without glue
+//   - arpa, delegated to ns1.example.com with glue
+func testTransport() *MemoryTransport {
+	host := func(name string) DomainName { return NewDomainName(name) }
+	addr := func(ip netip.Addr) A { return A{Addr: ip} }
+
+	t := NewMemoryTransport()
+	t.AddZone(Zone{Origin: "", Records: []ResourceRecord{
+		testSOA(""),
+		testRecord("com", NS{Host: host("a.gtld-servers.net")}),
+		testRecord("net", NS{Host: host("a.gtld-servers.net")}),
+		testRecord("a.gtld-servers.net", addr(testGTLD)),
+		testRecord("arpa", NS{Host: host("ns1.example.com")}),
+		testRecord("ns1.example.com", addr(testExCom)),
+	}}, testRoot)
+	t.AddZone(Zone{Origin: "com", Records: []ResourceRecord{
+		testSOA("com"),
+		testRecord("google.com", NS{Host: host("ns1.google.com")}),
+		testRecord("ns1.google.com", addr(testGoogle)),
+		testRecord("example.com", NS{Host: host("ns1.example.com")}),
+		testRecord("ns1.example.com", addr(testExCom)),
+		testRecord("glueless.com", NS{Host: host("ns.example.net")}),
+	}}, testGTLD)
+	t.AddZone(Zone{Origin: "net", Records: []ResourceRecord{
+		testSOA("net"),
+		testRecord("example.net", NS{Host: host("ns.example.net")}),
+		testRecord("ns.example.net", addr(testExNet)),
+	}}, testGTLD)
+	t.AddZone(Zone{Origin: "google.com", Records: []ResourceRecord{
+		testSOA("google.com"),
+		testRecord("dns.google.com", addr(netip.MustParseAddr("8.8.8.8"))),
+		testRecord("dns.google.com", addr(netip.MustParseAddr("8.8.4.4"))),
+	}}, testGoogle, testPublic)
+	t.AddZone(Zone{Origin: "example.com", Records: []ResourceRecord{
+		testSOA("example.com"),
+		testRecord("example.com", NS{Host: host("ns1.example.com")}),
+		testRecord("ns1.example.com", addr(testExCom)),
+		testRecord("example.com", addr(netip.MustParseAddr("192.0.2.1"))),
+		testRecord("www.example.com", CNAME{Target: host("example.com")}),
+		testRecord("alias.example.com", CNAME{Target: host("www.glueless.com")}),
+		testRecord("loop1.example.com", CNAME{Target: host("loop2.example.com")}),
+		testRecord("loop2.example.com", CNAME{Target: host("loop1.example.com")}),
+		testRecord("example.com", MX{Preference: 20, Exchange: host("mx2.example.com")}),
+		testRecord("example.com", MX{Preference: 10, Exchange: host("mx1.example.com")}),
+		testRecord("example.com", TXT{Strings: []string{"v=spf1 ", "-all"}}),
+		testRecord("_sip._tcp.example.com", SRV{Priority: 20, Weight: 0, Port: 5060, Target: host("backup.example.com")}),
+		testRecord("_sip._tcp.example.com", SRV{Priority: 10, Weight: 60, Port: 5060, Target: host("sip1.example.com")}),
+		testRecord("_sip._tcp.example.com", SRV{Priority: 10, Weight: 40, Port: 5060, Target: host("sip2.example.com")}),
+	}}, testExCom)
+	t.AddZone(Zone{Origin: "arpa", Records: []ResourceRecord{
+		testSOA("arpa"),
+		testRecord("1.2.0.192.in-addr.arpa", PTR{Host: host("example.com")}),
+		testRecord("2.2.0.192.in-addr.arpa", PTR{Host: host("example.com")}),
+		testRecord("2.2.0.192.in-addr.arpa", PTR{Host: host("www.glueless.com")}),
+		testRecord("3.2.0.192.in-addr.arpa", PTR{Host: host("example.com")}),
+		testRecord("5.2.0.192.in-addr.arpa", PTR{Host: host("missing.example.com")}),
+	}}, testExCom)
+	t.AddZone(Zone{Origin: "example.net", Records: []ResourceRecord{
+		testSOA("example.net"),
+		testRecord("ns.example.net", addr(testExNet)),
+	}}, testExNet)
+	t.AddZone(Zone{Origin: "glueless.com", Records: []ResourceRecord{
+		testSOA("glueless.com"),
+		testRecord("www.glueless.com", addr(netip.MustParseAddr("192.0.2.2"))),
+	}}, testExNet)
+
+	return t
+}
+
+func testResolver(transport Transport, opts ...ResolverOptsFunc) *Resolver {
+	return NewResolver(append([]ResolverOptsFunc{
+		WithRootHints(testRoot),
+		WithIPv6(false),
+		WithTransport(transport),
+	}, opts...)...)
+}
+
+func Test_resolve(t *testing.T) {
+	tests := map[string]struct {
+		name  string
+		qtype uint16
+		chain []string
+		want  []string
+		err   error
+	}{
+		"referrals with glue":    {name: "example.com", qtype: RecordTypeA, want: []string{"192.0.2.1"}},
+		"cname in zone":          {name: "www.example.com", qtype: RecordTypeA, chain: []string{"example.com."}, want: []string{"192.0.2.1"}},
+		"cname to glueless zone": {name: "alias.example.com", qtype: RecordTypeA, chain: []string{"www.glueless.com."}, want: []string{"192.0.2.2"}},
+		"case insensitive":       {name: "DNS.Google.COM", qtype: RecordTypeA, want: []string{"8.8.4.4", "8.8.8.8"}},
+		"nxdomain":               {name: "missing.example.com", qtype: RecordTypeA, err: ErrNXDomain},
+		"nodata":                 {name: "example.com", qtype: RecordTypeAAAA, err: ErrNoData},
+		"cname loop":             {name: "loop1.example.com", qtype: RecordTypeA, err: ErrCNAMELoop},
+	}
+
+	for name, tt := range tests {
+		r := testResolver(testTransport())
+		result, err := r.Resolve(context.Background(), tt.name, tt.qtype)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("%s: expected error %s but got %v", name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: expected no error but got %s", name, err)
+		}
+
+		chain := []string{}
+		for _, rr := range result.Chain {
+			chain = append(chain, rr.RData.String())
+		}
+		if tt.chain == nil {
+			tt.chain = []string{}
+		}
+		if !reflect.DeepEqual(tt.chain, chain) {
+			t.Fatalf("%s: expected chain to be\n%v\nbut got\n%v\n", name, tt.chain, chain)
+		}
+		got := result.Addresses()
+		slices.Sort(got)
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Fatalf("%s: expected addresses to be\n%v\nbut got\n%v\n", name, tt.want, got)
+		}
+	}
+}
+
+func Test_resolveCached(t *testing.T) {
+	transport := testTransport()
+	r := testResolver(transport)
+
+	for _, name := range []string{"www.example.com", "www.example.com", "missing.example.com", "missing.example.com"} {
+		r.Resolve(context.Background(), name, RecordTypeA)
+	}
+	// root, com and example.com for the first lookup, then example.com
+	// only for the missing name
+	if want, got := 4, len(transport.Queries()); want != got {
+		t.Fatalf("expected %d queries but got %d: %v", want, got, transport.Queries())
+	}
+	// a miss for each first lookup and a hit for each repeated one, the
+	// cached cname being followed by a hit for example.com; the probes for
+	// cnames and delegations aren't counted
+	if want, got := (CacheStats{Hits: 3, Misses: 2}), r.CacheStats(); want.Hits != got.Hits || want.Misses != got.Misses {
+		t.Fatalf("expected stats to be\n%+v\nbut got\n%+v\n", want, got)
+	}
+
+	// the delegation of com is cached, unlike that of net
+	r.Resolve(context.Background(), "www.glueless.com", RecordTypeA)
+	for _, q := range transport.Queries()[4:] {
+		if q.Server.Addr() == testRoot && q.Question.QName.String() != "ns.example.net" {
+			t.Fatalf("expected delegation of com to be cached but root was asked for %s", q.Question.QName)
+		}
+	}
+}
+
+func Test_resolveUnreachable(t *testing.T) {
+	transport := testTransport()
+	transport.Handle(testExCom, func(req Message) (Message, error) {
+		return NewMessage(WithID(req.Header.ID), WithResponse(), WithRCode(RCodeServFail)), nil
+	})
+
+	_, err := testResolver(transport).Resolve(context.Background(), "example.com", RecordTypeA)
+	if !errors.Is(err, ErrServerFailure) {
+		t.Fatalf("expected error %s but got %v", ErrServerFailure, err)
+	}
+
+	_, err = testResolver(NewMemoryTransport()).Resolve(context.Background(), "example.com", RecordTypeA)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected error %s but got %v", ErrTimeout, err)
+	}
+}
+
+// serveLocal answers queries on the same loopback port over UDP and TCP,
+// with the response handle returns for the network the query came in on.
+func serveLocal(t *testing.T, handle func(network string, req Message) Message) uint16 {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening on tcp %s", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	pc, err := net.ListenPacket("udp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("error listening on udp %s", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, maxUDPSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := Parse(bytes.NewReader(buf[:n]))
+			if err != nil {
+				continue
+			}
+			b, _ := handle("udp", req).Pack()
+			pc.WriteTo(b, addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			lengthBuf := make([]byte, 2)
+			buf := []byte{}
+			if _, err := io.ReadFull(conn, lengthBuf); err == nil {
+				buf = make([]byte, binary.BigEndian.Uint16(lengthBuf))
+				_, err = io.ReadFull(conn, buf)
+			}
+			if req, err := Parse(bytes.NewReader(buf)); err == nil {
+				b, _ := handle("tcp", req).Pack()
+				conn.Write(append(UInt16ToByteSlice(uint16(len(b))), b...))
+			}
+			conn.Close()
+		}
+	}()
+
+	return uint16(ln.Addr().(*net.TCPAddr).Port)
+}
+
+func Test_tcp(t *testing.T) {
+	var (
+		want = []string{"192.0.2.1"}
+
+		answer = ResourceRecord{
+			Name:  NewDomainName("example.com"),
+			Type:  RecordTypeA,
+			Class: RecordClassIN,
+			TTL:   300,
+			RData: A{Addr: netip.MustParseAddr("192.0.2.1")},
+		}
+	)
+
+	tests := map[string]struct {
+		opts     []ResolverOptsFunc
+		networks []string
+	}{
+		"fallback on truncation": {networks: []string{"udp", "tcp"}},
+		"tcp only":               {opts: []ResolverOptsFunc{WithTCPOnly(true)}, networks: []string{"tcp"}},
+	}
+
+	for name, tt := range tests {
+		var mu sync.Mutex
+		networks := []string{}
+		port := serveLocal(t, func(network string, req Message) Message {
+			mu.Lock()
+			networks = append(networks, network)
+			mu.Unlock()
+
+			resp := req
+			resp.Header.SetQR(true)
+			resp.Header.SetAA(true)
+			if network == "udp" {
+				resp.Header.SetTC(true)
+				return resp
+			}
+			resp.Answers = []ResourceRecord{answer}
+			return resp
+		})
+
+		r := NewResolver(append([]ResolverOptsFunc{
+			WithRootHints(netip.MustParseAddr("127.0.0.1")),
+			WithPort(port),
+			WithCache(nil),
+		}, tt.opts...)...)
+		result, err := r.Resolve(context.Background(), "example.com", RecordTypeA)
+		if err != nil {
+			t.Fatalf("%s: expected no error but got %s", name, err)
+		}
+		if got := result.Addresses(); !reflect.DeepEqual(want, got) {
+			t.Fatalf("%s: expected addresses to be\n%v\nbut got\n%v\n", name, want, got)
+		}
+
+		mu.Lock()
+		got := networks
+		mu.Unlock()
+		if !reflect.DeepEqual(tt.networks, got) {
+			t.Fatalf("%s: expected queries over\n%v\nbut got\n%v\n", name, tt.networks, got)
+		}
+	}
+
+	// running out of time while connecting is a timeout like any other
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	transport := &NetTransport{TCPOnly: true}
+	req := NewMessage(WithID(newID()), WithQuestion("example.com", RecordTypeA, RecordClassIN))
+	if _, err := transport.Exchange(ctx, netip.MustParseAddrPort("127.0.0.1:53"), req); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected error %s but got %v", ErrTimeout, err)
+	}
+}
+
+func Test_rejectSpoofed(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening on udp %s", err)
+	}
+	defer pc.Close()
+	spoofer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening on udp %s", err)
+	}
+	defer spoofer.Close()
+
+	go func() {
+		buf := make([]byte, maxUDPSize)
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req, _ := Parse(bytes.NewReader(buf[:n]))
+		answer := func(id uint16, name string) []byte {
+			b, _ := NewMessage(
+				WithID(id),
+				WithResponse(),
+				WithQuestion(name, RecordTypeA, RecordClassIN),
+			).Pack()
+			return b
+		}
+
+		spoofer.WriteTo(answer(req.Header.ID, "example.com"), addr)
+		pc.WriteTo(answer(req.Header.ID+1, "example.com"), addr)
+		pc.WriteTo(answer(req.Header.ID, "example.org"), addr)
+		pc.WriteTo(answer(req.Header.ID, "example.com")[:7], addr)
+		pc.WriteTo(answer(req.Header.ID, "EXAMPLE.com"), addr)
+	}()
+
+	transport := &NetTransport{}
+	req := NewMessage(WithQuestion("example.com", RecordTypeA, RecordClassIN))
+	// what goes on the wire follows changes made after NewMessage
+	req.Header.ID = newID()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := transport.Exchange(ctx, netip.MustParseAddrPort(pc.LocalAddr().String()), req)
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if resp.Questions[0].QName.String() != "EXAMPLE.com" {
+		t.Fatalf("expected the matching response but got one for %s", resp.Questions[0].QName)
+	}
+	if want, got := uint64(4), transport.Rejected(); want != got {
+		t.Fatalf("expected %d rejected packets but got %d", want, got)
+	}
+
+	memory := testTransport()
+	memory.Handle(testRoot, func(req Message) (Message, error) {
+		resp := answerFromZones([]Zone{{Origin: "", Records: []ResourceRecord{testSOA("")}}}, req)
+		resp.Header.ID++
+		return resp, nil
+	})
+	r := testResolver(memory, WithRetries(1))
+	if _, err := r.Resolve(context.Background(), "example.com", RecordTypeA); !errors.Is(err, ErrMismatchedResponse) {
+		t.Fatalf("expected error %s but got %v", ErrMismatchedResponse, err)
+	}
+	if want, got := uint64(2), r.Rejected(); want != got {
+		t.Fatalf("expected %d rejected responses but got %d", want, got)
+	}
+
+	// only failures may leave the question out, a spoofed NXDOMAIN can't
+	memory = testTransport()
+	memory.Handle(testRoot, func(req Message) (Message, error) {
+		resp := NewMessage(WithID(req.Header.ID), WithResponse(), WithRCode(RCodeNXDomain))
+		resp.Questions = nil
+		resp.Authority = []ResourceRecord{testSOA("")}
+		return resp, nil
+	})
+	r = testResolver(memory, WithRetries(1))
+	if _, err := r.Resolve(context.Background(), "example.com", RecordTypeA); !errors.Is(err, ErrMismatchedResponse) {
+		t.Fatalf("expected error %s but got %v", ErrMismatchedResponse, err)
+	}
+	if _, ok := r.cache.GetNegative("example.com", RecordTypeA, RecordClassIN); ok {
+		t.Fatalf("expected a response without the question not to be cached")
+	}
+	memory.Handle(testRoot, func(req Message) (Message, error) {
+		resp := NewMessage(WithID(req.Header.ID), WithResponse(), WithRCode(RCodeRefused))
+		resp.Questions = nil
+		return resp, nil
+	})
+	if _, err := r.Resolve(context.Background(), "example.com", RecordTypeA); !errors.Is(err, ErrServerFailure) {
+		t.Fatalf("expected error %s but got %v", ErrServerFailure, err)
+	}
+}
+
+func Test_bailiwick(t *testing.T) {
+	attacker := netip.MustParseAddr("203.0.113.66")
+	referral := func(authority []ResourceRecord, additional []ResourceRecord) func(Message) (Message, error) {
+		return func(req Message) (Message, error) {
+			resp := NewMessage(WithID(req.Header.ID), WithResponse())
+			resp.Questions = req.Questions
+			resp.Authority = authority
+			resp.Additional = additional
+			return resp, nil
+		}
+	}
+
+	tests := map[string]struct {
+		handle func(Message) (Message, error)
+		err    error
+	}{
+		"sideways referral": {
+			handle: referral([]ResourceRecord{testRecord("org", NS{Host: NewDomainName("ns.org")})}, nil),
+			err:    ErrLameDelegation,
+		},
+		"upward referral": {
+			handle: referral([]ResourceRecord{testRecord("", NS{Host: NewDomainName("a.gtld-servers.net")})}, nil),
+			err:    ErrLameDelegation,
+		},
+		"out of bailiwick glue": {
+			handle: referral(
+				[]ResourceRecord{testRecord("example.com", NS{Host: NewDomainName("ns.evil.org")})},
+				[]ResourceRecord{
+					testRecord("ns.evil.org", A{Addr: attacker}),
+					testRecord("www.victim.org", A{Addr: attacker}),
+				},
+			),
+			err: ErrLameDelegation,
+		},
+	}
+
+	for name, tt := range tests {
+		transport := testTransport()
+		transport.Handle(testGTLD, tt.handle)
+		cache := NewCache(defaultCacheSize)
+		r := testResolver(transport, WithCache(cache))
+
+		if _, err := r.Resolve(context.Background(), "example.com", RecordTypeA); !errors.Is(err, tt.err) {
+			t.Fatalf("%s: expected error %s but got %v", name, tt.err, err)
+		}
+		for _, q := range transport.Queries() {
+			if q.Server.Addr() == attacker {
+				t.Fatalf("%s: expected out of bailiwick glue to be ignored but %s was asked for %s", name, attacker, q.Question.QName)
+			}
+		}
+		for poisoned, typ := range map[string]uint16{"www.victim.org": RecordTypeA, "ns.evil.org": RecordTypeA, "org": RecordTypeNS, "": RecordTypeNS} {
+			if _, ok := cache.Get(poisoned, typ, RecordClassIN); ok {
+				t.Fatalf("%s: expected %q %d not to be cached", name, poisoned, typ)
+			}
+		}
+	}
+}
+
+func Test_glue(t *testing.T) {
+	attacker := netip.MustParseAddr("203.0.113.66")
+	transport := testTransport()
+	transport.Handle(testGTLD, func(req Message) (Message, error) {
+		resp := NewMessage(WithID(req.Header.ID), WithResponse())
+		resp.Questions = req.Questions
+		resp.Authority = []ResourceRecord{testRecord("example.com", NS{Host: NewDomainName("ns1.example.com")})}
+		resp.Additional = []ResourceRecord{
+			testRecord("ns1.example.com", A{Addr: testExCom}),
+			testRecord("www.bank.com", A{Addr: attacker}),
+			testRecord("www.example.com", A{Addr: attacker}),
+		}
+		return resp, nil
+	})
+	cache := NewCache(defaultCacheSize)
+	r := testResolver(transport, WithCache(cache))
+	ctx := context.Background()
+
+	if _, err := r.Resolve(ctx, "example.com", RecordTypeA); err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	for _, name := range []string{"www.bank.com", "www.example.com", "ns1.example.com"} {
+		if _, ok := cache.Get(name, RecordTypeA, RecordClassIN); ok {
+			t.Fatalf("expected %s not to be cached as an answer", name)
+		}
+	}
+
+	result, err := r.Resolve(ctx, "www.example.com", RecordTypeA)
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if want, got := []string{"192.0.2.1"}, result.Addresses(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected addresses %v but got %v", want, got)
+	}
+	if result, err := r.Resolve(ctx, "www.bank.com", RecordTypeA); err == nil {
+		t.Fatalf("expected unrelated additional records not to be served but got %v", result.Addresses())
+	}
+
+	// the glue only leads to the nameserver, which is asked for its address
+	before := len(transport.Queries())
+	if _, err := r.Resolve(ctx, "ns1.example.com", RecordTypeA); err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if queries := transport.Queries()[before:]; len(queries) != 1 || queries[0].Server.Addr() != testExCom {
+		t.Fatalf("expected a single query to %s but got %v", testExCom, queries)
+	}
+}
+
+func Test_trace(t *testing.T) {
+	steps := []TraceStep{}
+	r := testResolver(testTransport(), WithTrace(func(step TraceStep) {
+		steps = append(steps, step)
+	}))
+	if _, err := r.Resolve(context.Background(), "www.example.com", RecordTypeA); err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+
+	want := []string{
+		". 198.41.0.4:53 NOERROR 0 1 1",
+		"com. 192.5.6.30:53 NOERROR 0 1 1",
+		"example.com. 198.51.100.1:53 NOERROR 2 0 0",
+	}
+	got := []string{}
+	for _, step := range steps {
+		if step.Err != nil || step.Attempt != 1 {
+			t.Fatalf("expected a first attempt without error but got attempt %d with %v", step.Attempt, step.Err)
+		}
+		resp := step.Response
+		got = append(got, fmt.Sprintf("%s. %s %s %d %d %d", step.Zone, step.Server, resp.RCode(), len(resp.Answers), len(resp.Authority), len(resp.Additional)))
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected trace to be\n%v\nbut got\n%v\n", want, got)
+	}
+}
+
+func Test_forwarders(t *testing.T) {
+	transport := testTransport()
+	r := testResolver(transport, WithForwarders(testPublic))
+
+	result, err := r.Resolve(context.Background(), "dns.google.com", RecordTypeA)
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if want, got := 2, len(result.Answers); want != got {
+		t.Fatalf("expected %d answers but got %d", want, got)
+	}
+
+	queries := transport.Queries()
+	if len(queries) != 1 || queries[0].Server.Addr() != testPublic {
+		t.Fatalf("expected a single query to %s but got %v", testPublic, queries)
+	}
+}
+
+func Test_lookups(t *testing.T) {
+	r := testResolver(testTransport())
+	ctx := context.Background()
+
+	mxs, err := r.LookupMX(ctx, "example.com")
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if want, got := "[10 mx1.example.com. 20 mx2.example.com.]", fmt.Sprint(mxs); want != got {
+		t.Fatalf("expected mx records %s but got %s", want, got)
+	}
+
+	txts, err := r.LookupTXT(ctx, "example.com")
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if want := []string{"v=spf1 -all"}; !reflect.DeepEqual(want, txts) {
+		t.Fatalf("expected txt records %q but got %q", want, txts)
+	}
+
+	soa, err := r.LookupSOA(ctx, "example.com")
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if want, got := "ns.example.com", soa.MName.String(); want != got {
+		t.Fatalf("expected soa mname %s but got %s", want, got)
+	}
+
+	hosts, err := r.LookupPTR(ctx, "1.2.0.192.in-addr.arpa")
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if want := []string{"example.com"}; !reflect.DeepEqual(want, hosts) {
+		t.Fatalf("expected ptr records %v but got %v", want, hosts)
+	}
+
+	srvs, err := r.LookupSRV(ctx, "sip", "tcp", "example.com")
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if want, got := "backup.example.com", srvs[len(srvs)-1].Target.String(); len(srvs) != 3 || want != got {
+		t.Fatalf("expected 3 srv records ending with %s but got %v", want, srvs)
+	}
+
+	if _, err := r.LookupMX(ctx, "www.glueless.com"); !errors.Is(err, ErrNoData) {
+		t.Fatalf("expected %s but got %v", ErrNoData, err)
+	}
+}
+
+func Test_orderSRV(t *testing.T) {
+	srvs := []SRV{
+		{Priority: 20, Weight: 10, Target: NewDomainName("c.example.com")},
+		{Priority: 10, Weight: 0, Target: NewDomainName("zero.example.com")},
+		{Priority: 10, Weight: 90, Target: NewDomainName("heavy.example.com")},
+		{Priority: 10, Weight: 10, Target: NewDomainName("light.example.com")},
+	}
+
+	firsts := map[string]int{}
+	for range 1000 {
+		ordered := OrderSRV(srvs)
+		if len(ordered) != len(srvs) {
+			t.Fatalf("expected %d records but got %v", len(srvs), ordered)
+		}
+		if got := ordered[len(ordered)-1].Target.String(); got != "c.example.com" {
+			t.Fatalf("expected the lowest priority last but got %s", got)
+		}
+		firsts[ordered[0].Target.String()]++
+	}
+
+	// heavy is picked first about 90 times in 101
+	if n := firsts["heavy.example.com"]; n < 800 || n > 970 {
+		t.Fatalf("expected the heaviest record first about 890 times in 1000 but got %d", n)
+	}
+	if firsts["zero.example.com"] > firsts["light.example.com"] {
+		t.Fatalf("expected a record of weight 0 to be picked first less often than one of weight 10 but got %v", firsts)
+	}
+}
+
+func Test_reverseLookup(t *testing.T) {
+	transport := testTransport()
+	r := testResolver(transport)
+	ctx := context.Background()
+
+	names, err := r.ReverseLookup(ctx, netip.MustParseAddr("192.0.2.2"))
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if want := []string{"example.com", "www.glueless.com"}; !reflect.DeepEqual(want, names) {
+		t.Fatalf("expected names %v but got %v", want, names)
+	}
+
+	names, err = r.VerifiedReverseLookup(ctx, netip.MustParseAddr("::ffff:192.0.2.2"))
+	if err != nil {
+		t.Fatalf("expected no error but got %s", err)
+	}
+	if want := []string{"www.glueless.com"}; !reflect.DeepEqual(want, names) {
+		t.Fatalf("expected confirmed names %v but got %v", want, names)
+	}
+
+	if _, err := r.VerifiedReverseLookup(ctx, netip.MustParseAddr("192.0.2.3")); !errors.Is(err, ErrNotConfirmed) {
+		t.Fatalf("expected %s but got %v", ErrNotConfirmed, err)
+	}
+	queries := len(transport.Queries())
+	if _, err := r.ReverseLookup(ctx, netip.Addr{}); err == nil || len(transport.Queries()) != queries {
+		t.Fatalf("expected the zero address to fail without a query but got %v", err)
+	}
+	// a name that can't be resolved isn't known not to lead back
+	if _, err := r.VerifiedReverseLookup(ctx, netip.MustParseAddr("192.0.2.5")); !errors.Is(err, ErrNXDomain) {
+		t.Fatalf("expected %s but got %v", ErrNXDomain, err)
+	}
+	if _, err := r.ReverseLookup(ctx, netip.MustParseAddr("192.0.2.4")); !errors.Is(err, ErrNXDomain) {
+		t.Fatalf("expected %s but got %v", ErrNXDomain, err)
+	}
+}
@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// ReverseName returns the name under in-addr.arpa or ip6.arpa that PTR
+// records of addr are owned by. It fails for the zero Addr.
+func ReverseName(addr netip.Addr) (string, error) {
+	if !addr.IsValid() {
+		return "", fmt.Errorf("no reverse name for %s", addr)
+	}
+	addr = addr.Unmap()
+	b := addr.AsSlice()
+	labels := make([]string, 0, 2*len(b)+2)
+	if addr.Is4() {
+		for i := len(b) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprint(b[i]))
+		}
+		return strings.Join(append(labels, "in-addr", "arpa"), "."), nil
+	}
+	for i := len(b) - 1; i >= 0; i-- {
+		labels = append(labels, fmt.Sprintf("%x", b[i]&0xf), fmt.Sprintf("%x", b[i]>>4))
+	}
+	return strings.Join(append(labels, "ip6", "arpa"), "."), nil
+}
+
+// ReverseLookup resolves the names of addr from the PTR records of its
+// ReverseName. Anyone controlling the reverse zone of addr can claim any
+// name, which VerifiedReverseLookup guards against.
+func (r *Resolver) ReverseLookup(ctx context.Context, addr netip.Addr) ([]string, error) {
+	name, err := ReverseName(addr)
+	if err != nil {
+		return nil, err
+	}
+	return r.LookupPTR(ctx, name)
+}
+
+// VerifiedReverseLookup returns the names of addr that resolve back to it,
+// forward-confirmed reverse DNS. It fails with ErrNotConfirmed when all of
+// them were resolved and none leads back to addr, and with the error of the
+// first name that couldn't be resolved when some of them weren't.
+func (r *Resolver) VerifiedReverseLookup(ctx context.Context, addr netip.Addr) ([]string, error) {
+	names, err := r.ReverseLookup(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	addr = addr.Unmap()
+	qtype := RecordTypeAAAA
+	if addr.Is4() {
+		qtype = RecordTypeA
+	}
+	confirmed := []string{}
+	var lookupErr error
+	for _, name := range names {
+		addrs, err := r.LookupAddrs(ctx, name, qtype)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			if lookupErr == nil {
+				lookupErr = err
+			}
+			continue
+		}
+		if slices.ContainsFunc(addrs, func(a netip.Addr) bool { return a.Unmap() == addr }) {
+			confirmed = append(confirmed, name)
+		}
+	}
+	if len(confirmed) == 0 && lookupErr != nil {
+		return nil, lookupErr
+	}
+	if len(confirmed) == 0 {
+		return nil, fmt.Errorf("%s: %w", addr, ErrNotConfirmed)
+	}
+	return confirmed, nil
+}
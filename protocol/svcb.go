@@ -0,0 +1,580 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SvcParamKeys, RFC 9460 and RFC 9461
+	SvcParamMandatory     uint16 = 0
+	SvcParamALPN          uint16 = 1
+	SvcParamNoDefaultALPN uint16 = 2
+	SvcParamPort          uint16 = 3
+	SvcParamIPv4Hint      uint16 = 4
+	SvcParamECH           uint16 = 5
+	SvcParamIPv6Hint      uint16 = 6
+	SvcParamDoHPath       uint16 = 7
+	SvcParamOHTTP         uint16 = 8
+)
+
+var svcParamKeyNames = map[uint16]string{
+	SvcParamMandatory:     "mandatory",
+	SvcParamALPN:          "alpn",
+	SvcParamNoDefaultALPN: "no-default-alpn",
+	SvcParamPort:          "port",
+	SvcParamIPv4Hint:      "ipv4hint",
+	SvcParamECH:           "ech",
+	SvcParamIPv6Hint:      "ipv6hint",
+	SvcParamDoHPath:       "dohpath",
+	SvcParamOHTTP:         "ohttp",
+}
+
+// SvcParamKeyString returns the name of key, or keyN for keys without one.
+func SvcParamKeyString(key uint16) string {
+	if name, ok := svcParamKeyNames[key]; ok {
+		return name
+	}
+	return "key" + strconv.Itoa(int(key))
+}
+
+func parseSvcParamKey(s string) (uint16, error) {
+	for key, name := range svcParamKeyNames {
+		if name == s {
+			return key, nil
+		}
+	}
+	if n, ok := strings.CutPrefix(s, "key"); ok {
+		if key, err := strconv.ParseUint(n, 10, 16); err == nil {
+			return uint16(key), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown svc param key %q", s)
+}
+
+// SVCB is the rdata of a service binding record, RFC 9460. A Priority of
+// 0 makes the record an alias of Target, which then has no params.
+type SVCB struct {
+	Priority uint16
+	Target   DomainName
+	Params   []SvcParam
+}
+
+func unpackSVCB(r wireReader, length int) (SVCB, error) {
+	start := r.offset()
+	buf, err := read(r, 2)
+	if err != nil {
+		return SVCB{}, err
+	}
+	target, err := parseDomainName(r)
+	if err != nil {
+		return SVCB{}, fmt.Errorf("reading target: %w", err)
+	}
+
+	params := []SvcParam{}
+	for r.offset()-start < length {
+		header, err := read(r, 4)
+		if err != nil {
+			return SVCB{}, err
+		}
+		key := binary.BigEndian.Uint16(header[0:2])
+		if len(params) != 0 && key <= params[len(params)-1].Key() {
+			return SVCB{}, fmt.Errorf("svc param %s is out of order", SvcParamKeyString(key))
+		}
+		data, err := read(r, int(binary.BigEndian.Uint16(header[2:4])))
+		if err != nil {
+			return SVCB{}, fmt.Errorf("reading svc param %s: %w", SvcParamKeyString(key), err)
+		}
+		param, err := unpackSvcParam(key, data)
+		if err != nil {
+			return SVCB{}, fmt.Errorf("reading svc param %s: %w", SvcParamKeyString(key), err)
+		}
+		params = append(params, param)
+	}
+	return SVCB{
+		Priority: binary.BigEndian.Uint16(buf),
+		Target:   target,
+		Params:   params,
+	}, nil
+}
+
+func (s SVCB) Type() uint16 {
+	return RecordTypeSVCB
+}
+
+func (s SVCB) String() string {
+	fields := []string{strconv.Itoa(int(s.Priority)), s.Target.fqdn()}
+	for _, p := range s.Params {
+		fields = append(fields, p.String())
+	}
+	return strings.Join(fields, " ")
+}
+
+// pack writes the target uncompressed as RFC 9460 requires.
+func (s SVCB) pack(e *encoder) {
+	e.uint16(s.Priority)
+	e.name(s.Target, false)
+	for _, p := range s.Params {
+		e.uint16(p.Key())
+		done := e.length()
+		p.pack(e)
+		done()
+	}
+}
+
+// Param returns the param of s with key.
+func (s SVCB) Param(key uint16) (SvcParam, bool) {
+	for _, p := range s.Params {
+		if p.Key() == key {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// ALPN returns the protocol ids of the alpn param, which doesn't include
+// the default ones.
+func (s SVCB) ALPN() []string {
+	p, _ := svcParam[SvcALPN](s)
+	return p.IDs
+}
+
+// Port returns the port of the port param, if s has one.
+func (s SVCB) Port() (uint16, bool) {
+	p, ok := svcParam[SvcPort](s)
+	return p.Port, ok
+}
+
+// IPv4Hint returns the addresses of the ipv4hint param.
+func (s SVCB) IPv4Hint() []netip.Addr {
+	p, _ := svcParam[SvcIPv4Hint](s)
+	return p.Addrs
+}
+
+// IPv6Hint returns the addresses of the ipv6hint param.
+func (s SVCB) IPv6Hint() []netip.Addr {
+	p, _ := svcParam[SvcIPv6Hint](s)
+	return p.Addrs
+}
+
+// ECH returns the ECHConfigList of the ech param.
+func (s SVCB) ECH() []byte {
+	p, _ := svcParam[SvcECH](s)
+	return p.Config
+}
+
+func svcParam[T SvcParam](s SVCB) (T, bool) {
+	for _, p := range s.Params {
+		if p, ok := p.(T); ok {
+			return p, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// parseSVCB parses the presentation fields of an SVCB or HTTPS rdata,
+// sorting its params by key as the wire format requires.
+func parseSVCB(fields []string) (SVCB, error) {
+	if len(fields) < 2 {
+		return SVCB{}, fmt.Errorf("svcb rdata has %d fields, fewer than 2", len(fields))
+	}
+	priority, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return SVCB{}, err
+	}
+
+	params := []SvcParam{}
+	for _, field := range fields[2:] {
+		name, value, hasValue := strings.Cut(field, "=")
+		key, err := parseSvcParamKey(name)
+		if err != nil {
+			return SVCB{}, err
+		}
+		if hasValue {
+			if value, err = unquoteField(value); err != nil {
+				return SVCB{}, fmt.Errorf("reading svc param %s: %w", name, err)
+			}
+		}
+		param, err := parseSvcParam(key, value, hasValue)
+		if err != nil {
+			return SVCB{}, fmt.Errorf("reading svc param %s: %w", name, err)
+		}
+		params = append(params, param)
+	}
+	slices.SortFunc(params, func(a, b SvcParam) int {
+		return int(a.Key()) - int(b.Key())
+	})
+	for i := 1; i < len(params); i++ {
+		if params[i].Key() == params[i-1].Key() {
+			return SVCB{}, fmt.Errorf("svc param %s is repeated", SvcParamKeyString(params[i].Key()))
+		}
+	}
+
+	target, err := parseName(fields[1])
+	if err != nil {
+		return SVCB{}, err
+	}
+	return SVCB{
+		Priority: uint16(priority),
+		Target:   target,
+		Params:   params,
+	}, nil
+}
+
+// HTTPS is the rdata of an HTTPS record, an SVCB record for HTTP origins.
+type HTTPS struct {
+	SVCB
+}
+
+func (h HTTPS) Type() uint16 {
+	return RecordTypeHTTPS
+}
+
+// SvcParam is a param of an SVCB or HTTPS record. Params without a
+// dedicated type are kept as UnknownSvcParam.
+type SvcParam interface {
+	Key() uint16
+	// String returns the param in presentation format, key=value.
+	String() string
+
+	pack(e *encoder)
+}
+
+func unpackSvcParam(key uint16, data []byte) (SvcParam, error) {
+	switch key {
+	case SvcParamMandatory:
+		if len(data)%2 != 0 {
+			return nil, fmt.Errorf("mandatory is %d bytes long, not a multiple of 2", len(data))
+		}
+		keys := []uint16{}
+		for i := 0; i < len(data); i += 2 {
+			keys = append(keys, binary.BigEndian.Uint16(data[i:]))
+		}
+		return SvcMandatory{Keys: keys}, nil
+	case SvcParamALPN:
+		ids := []string{}
+		for len(data) != 0 {
+			n := int(data[0])
+			if n == 0 || 1+n > len(data) {
+				return nil, fmt.Errorf("alpn id of %d bytes does not fit the %d bytes left", n, len(data)-1)
+			}
+			ids = append(ids, string(data[1:1+n]))
+			data = data[1+n:]
+		}
+		return SvcALPN{IDs: ids}, nil
+	case SvcParamNoDefaultALPN:
+		return SvcNoDefaultALPN{}, emptySvcParam(data)
+	case SvcParamPort:
+		if len(data) != 2 {
+			return nil, fmt.Errorf("port is %d bytes long instead of 2", len(data))
+		}
+		return SvcPort{Port: binary.BigEndian.Uint16(data)}, nil
+	case SvcParamIPv4Hint:
+		addrs, err := unpackAddrs(data, 4)
+		return SvcIPv4Hint{Addrs: addrs}, err
+	case SvcParamECH:
+		return SvcECH{Config: data}, nil
+	case SvcParamIPv6Hint:
+		addrs, err := unpackAddrs(data, 16)
+		return SvcIPv6Hint{Addrs: addrs}, err
+	case SvcParamDoHPath:
+		return SvcDoHPath{Template: string(data)}, nil
+	case SvcParamOHTTP:
+		return SvcOHTTP{}, emptySvcParam(data)
+	default:
+		return UnknownSvcParam{ParamKey: key, Value: data}, nil
+	}
+}
+
+func parseSvcParam(key uint16, value string, hasValue bool) (SvcParam, error) {
+	switch key {
+	case SvcParamNoDefaultALPN, SvcParamOHTTP:
+		if hasValue {
+			return nil, fmt.Errorf("%s takes no value", SvcParamKeyString(key))
+		}
+		return unpackSvcParam(key, nil)
+	case SvcParamMandatory, SvcParamALPN, SvcParamPort, SvcParamIPv4Hint, SvcParamECH, SvcParamIPv6Hint, SvcParamDoHPath:
+		if value == "" {
+			return nil, fmt.Errorf("%s needs a value", SvcParamKeyString(key))
+		}
+	}
+
+	switch key {
+	case SvcParamMandatory:
+		keys := []uint16{}
+		for _, name := range strings.Split(value, ",") {
+			k, err := parseSvcParamKey(name)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		return SvcMandatory{Keys: keys}, nil
+	case SvcParamALPN:
+		return SvcALPN{IDs: splitValueList(value)}, nil
+	case SvcParamPort:
+		port, err := strconv.ParseUint(value, 10, 16)
+		return SvcPort{Port: uint16(port)}, err
+	case SvcParamIPv4Hint, SvcParamIPv6Hint:
+		addrs := []netip.Addr{}
+		for _, s := range strings.Split(value, ",") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			if addr.Is4() != (key == SvcParamIPv4Hint) {
+				return nil, fmt.Errorf("%s is not an address for %s", addr, SvcParamKeyString(key))
+			}
+			addrs = append(addrs, addr)
+		}
+		if key == SvcParamIPv4Hint {
+			return SvcIPv4Hint{Addrs: addrs}, nil
+		}
+		return SvcIPv6Hint{Addrs: addrs}, nil
+	case SvcParamECH:
+		config, err := base64.StdEncoding.DecodeString(value)
+		return SvcECH{Config: config}, err
+	case SvcParamDoHPath:
+		return SvcDoHPath{Template: value}, nil
+	default:
+		return UnknownSvcParam{ParamKey: key, Value: []byte(value)}, nil
+	}
+}
+
+func emptySvcParam(data []byte) error {
+	if len(data) != 0 {
+		return fmt.Errorf("param is %d bytes long instead of empty", len(data))
+	}
+	return nil
+}
+
+func unpackAddrs(data []byte, size int) ([]netip.Addr, error) {
+	if len(data) == 0 || len(data)%size != 0 {
+		return nil, fmt.Errorf("addresses are %d bytes long, not a multiple of %d", len(data), size)
+	}
+	addrs := []netip.Addr{}
+	for i := 0; i < len(data); i += size {
+		addr, _ := netip.AddrFromSlice(data[i : i+size])
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+func joinAddrs(addrs []netip.Addr) string {
+	strs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		strs = append(strs, addr.String())
+	}
+	return strings.Join(strs, ",")
+}
+
+// splitValueList splits a comma separated list of RFC 9460, where commas
+// and backslashes within values are escaped with a backslash.
+func splitValueList(s string) []string {
+	values := []string{}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			sb.WriteByte(s[i])
+		case s[i] == ',':
+			values = append(values, sb.String())
+			sb.Reset()
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return append(values, sb.String())
+}
+
+// SvcMandatory lists the keys of the params clients must understand to use
+// the record.
+type SvcMandatory struct {
+	Keys []uint16
+}
+
+func (m SvcMandatory) Key() uint16 {
+	return SvcParamMandatory
+}
+
+func (m SvcMandatory) String() string {
+	names := make([]string, 0, len(m.Keys))
+	for _, key := range m.Keys {
+		names = append(names, SvcParamKeyString(key))
+	}
+	return "mandatory=" + strings.Join(names, ",")
+}
+
+func (m SvcMandatory) pack(e *encoder) {
+	for _, key := range m.Keys {
+		e.uint16(key)
+	}
+}
+
+// SvcALPN lists the ids of the protocols the service supports, such as h2
+// and h3.
+type SvcALPN struct {
+	IDs []string
+}
+
+func (a SvcALPN) Key() uint16 {
+	return SvcParamALPN
+}
+
+func (a SvcALPN) String() string {
+	escaped := make([]string, 0, len(a.IDs))
+	for _, id := range a.IDs {
+		escaped = append(escaped, strings.NewReplacer(`\`, `\\`, `,`, `\,`).Replace(id))
+	}
+	return "alpn=" + quoteCharacterString(strings.Join(escaped, ","))
+}
+
+func (a SvcALPN) pack(e *encoder) {
+	for _, id := range a.IDs {
+		if id == "" {
+			e.fail(fmt.Errorf("alpn ids can't be empty"))
+		}
+		e.characterString(id)
+	}
+}
+
+// SvcNoDefaultALPN tells that the service doesn't support the default
+// protocol of the scheme, http/1.1 for HTTPS records.
+type SvcNoDefaultALPN struct{}
+
+func (SvcNoDefaultALPN) Key() uint16 {
+	return SvcParamNoDefaultALPN
+}
+
+func (SvcNoDefaultALPN) String() string {
+	return "no-default-alpn"
+}
+
+func (SvcNoDefaultALPN) pack(e *encoder) {}
+
+type SvcPort struct {
+	Port uint16
+}
+
+func (p SvcPort) Key() uint16 {
+	return SvcParamPort
+}
+
+func (p SvcPort) String() string {
+	return "port=" + strconv.Itoa(int(p.Port))
+}
+
+func (p SvcPort) pack(e *encoder) {
+	e.uint16(p.Port)
+}
+
+type SvcIPv4Hint struct {
+	Addrs []netip.Addr
+}
+
+func (h SvcIPv4Hint) Key() uint16 {
+	return SvcParamIPv4Hint
+}
+
+func (h SvcIPv4Hint) String() string {
+	return "ipv4hint=" + joinAddrs(h.Addrs)
+}
+
+func (h SvcIPv4Hint) pack(e *encoder) {
+	for _, addr := range h.Addrs {
+		e.ipv4(addr)
+	}
+}
+
+// SvcECH holds the ECHConfigList clients encrypt their ClientHello with.
+type SvcECH struct {
+	Config []byte
+}
+
+func (ech SvcECH) Key() uint16 {
+	return SvcParamECH
+}
+
+func (ech SvcECH) String() string {
+	return "ech=" + base64.StdEncoding.EncodeToString(ech.Config)
+}
+
+func (ech SvcECH) pack(e *encoder) {
+	e.bytes(ech.Config)
+}
+
+type SvcIPv6Hint struct {
+	Addrs []netip.Addr
+}
+
+func (h SvcIPv6Hint) Key() uint16 {
+	return SvcParamIPv6Hint
+}
+
+func (h SvcIPv6Hint) String() string {
+	return "ipv6hint=" + joinAddrs(h.Addrs)
+}
+
+func (h SvcIPv6Hint) pack(e *encoder) {
+	for _, addr := range h.Addrs {
+		e.ipv6(addr)
+	}
+}
+
+// SvcDoHPath is the URI template of a DNS over HTTPS service, RFC 9461.
+type SvcDoHPath struct {
+	Template string
+}
+
+func (d SvcDoHPath) Key() uint16 {
+	return SvcParamDoHPath
+}
+
+func (d SvcDoHPath) String() string {
+	return "dohpath=" + quoteCharacterString(d.Template)
+}
+
+func (d SvcDoHPath) pack(e *encoder) {
+	e.bytes([]byte(d.Template))
+}
+
+// SvcOHTTP tells that the service is an Oblivious HTTP target, RFC 9540.
+type SvcOHTTP struct{}
+
+func (SvcOHTTP) Key() uint16 {
+	return SvcParamOHTTP
+}
+
+func (SvcOHTTP) String() string {
+	return "ohttp"
+}
+
+func (SvcOHTTP) pack(e *encoder) {}
+
+type UnknownSvcParam struct {
+	ParamKey uint16
+	Value    []byte
+}
+
+func (u UnknownSvcParam) Key() uint16 {
+	return u.ParamKey
+}
+
+func (u UnknownSvcParam) String() string {
+	if len(u.Value) == 0 {
+		return SvcParamKeyString(u.ParamKey)
+	}
+	return SvcParamKeyString(u.ParamKey) + "=" + quoteCharacterString(string(u.Value))
+}
+
+func (u UnknownSvcParam) pack(e *encoder) {
+	e.bytes(u.Value)
+}
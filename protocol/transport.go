@@ -0,0 +1,218 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"sync/atomic"
+)
+
+const (
+	maxUDPSize = 65535
+	maxTCPSize = 65535
+
+	// source ports below are commonly reserved
+	minSourcePort = 1024
+)
+
+// Transport sends a query to a nameserver and returns its response.
+type Transport interface {
+	Exchange(ctx context.Context, server netip.AddrPort, req Message) (Message, error)
+}
+
+// NetTransport exchanges messages with nameservers over the network, using
+// UDP and asking again over TCP when a response was truncated. Datagrams
+// that don't come from the server queried, can't be parsed or don't match
+// the query are discarded and counted. The zero value is ready for use.
+type NetTransport struct {
+	// TCPOnly makes every query go over TCP, for networks that filter DNS
+	// over UDP.
+	TCPOnly bool
+
+	rejected atomic.Uint64
+}
+
+func (t *NetTransport) Exchange(ctx context.Context, server netip.AddrPort, req Message) (Message, error) {
+	if t.TCPOnly {
+		return t.exchangeTCP(ctx, server, req)
+	}
+	resp, err := t.exchange(ctx, server, req)
+	if err != nil || !resp.Header.TC() {
+		return resp, err
+	}
+	return t.exchangeTCP(ctx, server, req)
+}
+
+// Rejected returns how many packets were discarded for not being a
+// response to the query they arrived for, parsable ones or not.
+func (t *NetTransport) Rejected() uint64 {
+	return t.rejected.Load()
+}
+
+// exchange sends req to server over UDP from a random port and waits for
+// a datagram that comes from server and answers req. Anyone can send a
+// datagram that fails to parse, which mustn't end the wait for the real
+// response any more than a mismatched one does.
+func (t *NetTransport) exchange(ctx context.Context, server netip.AddrPort, req Message) (Message, error) {
+	b, err := req.Pack()
+	if err != nil {
+		return Message{}, fmt.Errorf("querying %s: %w", server, err)
+	}
+	conn, err := listenUDP(server)
+	if err != nil {
+		return Message{}, fmt.Errorf("listening for %s: %w", server, err)
+	}
+	defer bind(ctx, conn)()
+
+	n, err := conn.WriteToUDPAddrPort(b, server)
+	if err != nil {
+		return Message{}, exchangeError(ctx, server, err)
+	}
+	if n != len(b) {
+		return Message{}, fmt.Errorf("wrote %d bytes but message is %d bytes long", n, len(b))
+	}
+
+	buf := make([]byte, maxUDPSize)
+	for {
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return Message{}, exchangeError(ctx, server, err)
+		}
+		if from.Addr().Unmap() != server.Addr().Unmap() || from.Port() != server.Port() {
+			t.rejected.Add(1)
+			continue
+		}
+
+		resp, err := Parse(bytes.NewReader(buf[:n]))
+		if err != nil || checkResponse(req, resp) != nil {
+			t.rejected.Add(1)
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// exchangeTCP sends req to server over TCP, where each message is preceded
+// by its length in two bytes.
+func (t *NetTransport) exchangeTCP(ctx context.Context, server netip.AddrPort, req Message) (Message, error) {
+	b, err := req.Pack()
+	if err != nil {
+		return Message{}, fmt.Errorf("querying %s: %w", server, err)
+	}
+	if len(b) > maxTCPSize {
+		return Message{}, fmt.Errorf("message is %d bytes long, longer than %d", len(b), maxTCPSize)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", server.String())
+	if err != nil {
+		return Message{}, exchangeError(ctx, server, err)
+	}
+	defer bind(ctx, conn)()
+
+	if _, err := conn.Write(append(UInt16ToByteSlice(uint16(len(b))), b...)); err != nil {
+		return Message{}, exchangeError(ctx, server, err)
+	}
+
+	lengthBuf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, lengthBuf); err != nil {
+		return Message{}, exchangeError(ctx, server, err)
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(lengthBuf))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return Message{}, exchangeError(ctx, server, err)
+	}
+
+	resp, err := Parse(bytes.NewReader(buf))
+	if err != nil {
+		return Message{}, fmt.Errorf("%w from %s: %w", ErrMalformedResponse, server, err)
+	}
+	if err := checkResponse(req, resp); err != nil {
+		t.rejected.Add(1)
+		return Message{}, fmt.Errorf("%w from %s: %w", ErrMismatchedResponse, server, err)
+	}
+
+	return resp, nil
+}
+
+// checkResponse tells why resp is not the response to req, if it isn't.
+// Servers may leave the question out of responses reporting a failure,
+// which are never cached, but answers and NXDOMAIN have to repeat it for a
+// spoofer to need more than the id.
+func checkResponse(req Message, resp Message) error {
+	if !resp.Header.QR() {
+		return errors.New("message is not a response")
+	}
+	if resp.Header.ID != req.Header.ID {
+		return fmt.Errorf("id %d does not match query id %d", resp.Header.ID, req.Header.ID)
+	}
+	if len(resp.Questions) == 0 {
+		switch resp.RCode() {
+		case RCodeFormErr, RCodeServFail, RCodeNotImp, RCodeRefused:
+			return nil
+		}
+	}
+	if len(resp.Questions) != len(req.Questions) {
+		return fmt.Errorf("%d questions do not match the %d of the query", len(resp.Questions), len(req.Questions))
+	}
+	for i, q := range resp.Questions {
+		want := req.Questions[i]
+		if !sameName(q.QName.String(), want.QName.String()) || q.QType != want.QType || q.QClass != want.QClass {
+			return fmt.Errorf("question %s %d %d does not match %s %d %d", q.QName, q.QType, q.QClass, want.QName, want.QType, want.QClass)
+		}
+	}
+	return nil
+}
+
+// listenUDP opens a socket for querying server on a random port, which
+// together with the query id keeps off-path attackers from guessing where
+// to send spoofed responses. It falls back to a port of the system's
+// choosing after a few ports in use.
+func listenUDP(server netip.AddrPort) (*net.UDPConn, error) {
+	network := "udp6"
+	if server.Addr().Unmap().Is4() {
+		network = "udp4"
+	}
+	for range 8 {
+		port := minSourcePort + rand.IntN(65536-minSourcePort)
+		if conn, err := net.ListenUDP(network, &net.UDPAddr{Port: port}); err == nil {
+			return conn, nil
+		}
+	}
+	return net.ListenUDP(network, nil)
+}
+
+// bind sets the deadline of ctx on conn and closes conn when ctx is done,
+// returning the func that releases conn.
+func bind(ctx context.Context, conn net.Conn) func() {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	return func() {
+		stop()
+		conn.Close()
+	}
+}
+
+func exchangeError(ctx context.Context, server netip.AddrPort, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if errors.Is(ctxErr, context.DeadlineExceeded) {
+			return fmt.Errorf("%w: querying %s: %w", ErrTimeout, server, ctxErr)
+		}
+		return fmt.Errorf("querying %s: %w", server, ctxErr)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("%w: querying %s: %w", ErrTimeout, server, err)
+	}
+	return fmt.Errorf("querying %s: %w", server, err)
+}